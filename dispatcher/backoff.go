@@ -0,0 +1,67 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	minPollInterval     = 1 * time.Second
+	defaultPollInterval = 60 * time.Second
+
+	// capacityPollInterval is the wait between polls while there is no room
+	// to launch anything.  A finished task frees room without Piazza having
+	// anything new to offer, so this does not back off.
+	capacityPollInterval = 5 * time.Second
+)
+
+// pollBackoff produces the waits between polls.  Each consecutive wait is
+// double the last, up to max, and every wait is jittered down by as much as
+// half so that several dispatchers started together drift apart.
+type pollBackoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+	rand    *rand.Rand
+}
+
+func newPollBackoff(min, max time.Duration) *pollBackoff {
+	if max < min {
+		max = min
+	}
+	return &pollBackoff{min: min, max: max, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Reset starts the backoff over from the minimum wait
+func (b *pollBackoff) Reset() {
+	b.current = 0
+}
+
+// Next returns how long to wait before the next poll, and backs off further
+// for the one after
+func (b *pollBackoff) Next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current *= 2
+		if b.current > b.max {
+			b.current = b.max
+		}
+	}
+	half := b.current / 2
+	return half + time.Duration(b.rand.Int63n(int64(half)+1))
+}
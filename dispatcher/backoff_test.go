@@ -0,0 +1,41 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollBackoff(t *testing.T) {
+	backoff := newPollBackoff(time.Second, 8*time.Second)
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i := 0; i < 3; i++ {
+		for step, full := range expected {
+			wait := backoff.Next()
+			if wait < full/2 || wait > full {
+				t.Errorf(`TestPollBackoff: wait %d was %v, expected between %v and %v.`, step, wait, full/2, full)
+			}
+		}
+		backoff.Reset()
+	}
+
+	backoff = newPollBackoff(time.Second, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if wait := backoff.Next(); wait > time.Second {
+			t.Errorf(`TestPollBackoff: wait %v above a max below the min.`, wait)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+// cancelJob terminates the CF task working the given job, and reports the job
+// to Piazza as cancelled.  Returns false if the job has no task running.
+func cancelJob(svc *dispatchService, cfClient *cfclient.Client, jobID string) (bool, error) {
+	s := svc.s.WithField("jobID", jobID)
+	job, ok := svc.tracker.Get(jobID)
+	if !ok {
+		return false, nil
+	}
+
+	pzsvc.LogAudit(s, s.AppName, "Terminating CF task for Job "+jobID+" on request", job.TaskGUID, "", pzsvc.INFO)
+	if err := cfClient.TerminateTask(job.TaskGUID); err != nil {
+		return true, pzsvc.LogSimpleErr(s, "Could not terminate CF task "+job.TaskGUID+" for Job "+jobID+": ", err)
+	}
+	svc.tracker.Forget(jobID)
+
+	pErr := pzsvc.SendExecResultError(s, s.PzAddr, svc.svcID, jobID, pzsvc.PiazzaStatusCancelled, "Job cancelled through the dispatcher")
+	if pErr != nil {
+		pErr.Log(s, "Could not report cancellation of Job "+jobID)
+	}
+	return true, nil
+}
+
+// cancelHandler serves POST /jobs/{jobID}/cancel, which cancels the running
+// task for a job.  The request must carry the same Authorization header the
+// dispatcher uses with Piazza for the job's service.
+func cancelHandler(services []*dispatchService, cfClient *cfclient.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 3 || parts[0] != "jobs" || parts[2] != "cancel" || parts[1] == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != "POST" {
+			pzsvc.HTTPOut(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		jobID := parts[1]
+
+		authorized := false
+		for _, svc := range services {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(svc.s.PzAuth)) != 1 {
+				continue
+			}
+			authorized = true
+			found, err := cancelJob(svc, cfClient, jobID)
+			if err != nil {
+				pzsvc.HTTPOut(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			if found {
+				pzsvc.HTTPOut(w, "Job "+jobID+" cancelled", http.StatusOK)
+				return
+			}
+		}
+		if !authorized {
+			pzsvc.HTTPOut(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		pzsvc.HTTPOut(w, "No running task for Job "+jobID, http.StatusNotFound)
+	}
+}
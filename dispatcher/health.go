@@ -0,0 +1,168 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+const defaultStallTimeout = 10 * time.Minute
+
+const (
+	// readyProbeTimeout is the longest any one readiness check may take
+	readyProbeTimeout = 5 * time.Second
+	// readyCacheTTL is how long a readiness result is reused, so that
+	// frequent probes do not each cost a round of calls to Piazza and CF
+	readyCacheTTL = 10 * time.Second
+)
+
+// lastPollTick is the time, in Unix nanoseconds, at which the polling loop
+// last started a pass
+var lastPollTick int64
+
+func markPollTick() {
+	atomic.StoreInt64(&lastPollTick, time.Now().UnixNano())
+}
+
+func sincePollTick() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&lastPollTick)))
+}
+
+// minStallTimeout returns the shortest stall timeout that a healthy polling
+// loop cannot trip: twice the longest it may sleep between passes, whether
+// backing off while idle, waiting for room, or waiting for the lease
+func minStallTimeout(maxPollInterval time.Duration) time.Duration {
+	longest := maxPollInterval
+	for _, wait := range []time.Duration{leaseTTL / 4, capacityPollInterval} {
+		if wait > longest {
+			longest = wait
+		}
+	}
+	return 2 * longest
+}
+
+// healthStatus is the response body for the health and readiness endpoints
+type healthStatus struct {
+	Healthy bool              `json:"healthy"`
+	Checks  map[string]string `json:"checks"`
+}
+
+// healthzHandler reports the dispatcher healthy so long as the polling loop
+// has made a pass within the stall timeout
+func healthzHandler(stallTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := healthStatus{Healthy: true, Checks: map[string]string{"poll": "ok"}}
+		if since := sincePollTick(); since > stallTimeout {
+			status.Healthy = false
+			status.Checks["poll"] = "no poll for " + since.String()
+		}
+		writeHealth(w, status)
+	}
+}
+
+// readyzHandler reports the dispatcher ready if every service's Piazza
+// instance accepts its credentials, and CF is answering task queries.  Results
+// are reused for readyCacheTTL.
+func readyzHandler(services []*dispatchService, cfClient *cfclient.Client, appID string) http.HandlerFunc {
+	var (
+		mu        sync.Mutex
+		cached    healthStatus
+		checkedAt time.Time
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if checkedAt.IsZero() || time.Since(checkedAt) > readyCacheTTL {
+			cached = checkReady(services, cfClient, appID)
+			checkedAt = time.Now()
+		}
+		writeHealth(w, cached)
+	}
+}
+
+// checkReady runs the readiness checks, each under readyProbeTimeout
+func checkReady(services []*dispatchService, cfClient *cfclient.Client, appID string) healthStatus {
+	status := healthStatus{Healthy: true, Checks: map[string]string{}}
+	for _, svc := range services {
+		check := "piazza:" + svc.config.SvcName
+		ctx, cancel := context.WithTimeout(context.Background(), readyProbeTimeout)
+		probeSession := svc.s
+		probeSession.Context = ctx
+		if svc.svcID == "" {
+			status.Healthy = false
+			status.Checks[check] = "no service ID"
+		} else if err := pzsvc.CheckAuth(probeSession); err != nil {
+			status.Healthy = false
+			status.Checks[check] = err.Error()
+		} else {
+			status.Checks[check] = "ok"
+		}
+		cancel()
+	}
+
+	// The CF client takes no context, so the query is abandoned rather than
+	// cancelled if it runs out of time
+	cfResult := make(chan error, 1)
+	go func() {
+		query := url.Values{}
+		query.Add("states", "RUNNING")
+		_, err := cfClient.TasksByAppByQuery(appID, query)
+		cfResult <- err
+	}()
+	select {
+	case err := <-cfResult:
+		if err != nil {
+			status.Healthy = false
+			status.Checks["cf"] = err.Error()
+		} else {
+			status.Checks["cf"] = "ok"
+		}
+	case <-time.After(readyProbeTimeout):
+		status.Healthy = false
+		status.Checks["cf"] = "no answer within " + readyProbeTimeout.String()
+	}
+	return status
+}
+
+func writeHealth(w http.ResponseWriter, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	code := http.StatusOK
+	if !status.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+	pzsvc.PrintJSON(w, status, code)
+}
+
+// watchdog exits the process if the polling loop stops making passes, so
+// that the platform will restart it
+func watchdog(s pzsvc.Session, stallTimeout time.Duration) {
+	s.SessionID = "Watchdog"
+	for {
+		time.Sleep(stallTimeout / 10)
+		if since := sincePollTick(); since > stallTimeout {
+			pzsvc.LogSimpleErr(s, "Polling loop has stalled for "+since.String()+".  Exiting.", nil)
+			os.Exit(2)
+		}
+	}
+}
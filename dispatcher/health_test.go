@@ -0,0 +1,36 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMinStallTimeout(t *testing.T) {
+	testCases := []struct {
+		maxPollInterval time.Duration
+		expected        time.Duration
+	}{
+		{60 * time.Second, 120 * time.Second},
+		{time.Second, 2 * leaseTTL / 4},
+		{leaseTTL, 2 * leaseTTL},
+	}
+	for _, tc := range testCases {
+		if floor := minStallTimeout(tc.maxPollInterval); floor != tc.expected {
+			t.Errorf(`TestMinStallTimeout: %v gave %v, expected %v.`, tc.maxPollInterval, floor, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+// serveHTTP runs the dispatcher's own small HTTP interface, on the port given
+// by the PORT environment variable, or 8080 by default.
+func serveHTTP(s pzsvc.Session, handler http.Handler) {
+	s.SessionID = "HTTP"
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	pzsvc.LogInfo(s, "Serving dispatcher HTTP endpoints on port "+port)
+	err := http.ListenAndServe(":"+port, handler)
+	pzsvc.LogSimpleErr(s, "Dispatcher HTTP server stopped: ", err)
+}
@@ -0,0 +1,252 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+const (
+	leaseTTL         = 60 * time.Second
+	leaseLockTimeout = 5 * time.Second
+)
+
+// leaseBackend is a lock that several dispatcher instances contend for.  Only
+// the instance that holds the lease polls Piazza and launches tasks, so that
+// the check of running tasks against TASK_LIMIT and the creation of new ones
+// cannot interleave between instances.
+type leaseBackend interface {
+	// Acquire takes the lease for the given holder, or renews it if the holder
+	// already has it.  Returns true if the holder has the lease afterwards.
+	Acquire(holder string, ttl time.Duration) (bool, error)
+	// Release gives the lease up, if the given holder has it.
+	Release(holder string) error
+}
+
+// leaseState is the record of who holds a lease, and until when
+type leaseState struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// grant decides whether holder may have the lease, given its current state
+func (ls leaseState) grant(holder string, now time.Time) bool {
+	return ls.Holder == "" || ls.Holder == holder || now.After(ls.Expires)
+}
+
+// memoryLease is a leaseBackend shared only within the current process
+type memoryLease struct {
+	mu    sync.Mutex
+	state leaseState
+}
+
+func (ml *memoryLease) Acquire(holder string, ttl time.Duration) (bool, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	now := time.Now()
+	if !ml.state.grant(holder, now) {
+		return false, nil
+	}
+	ml.state = leaseState{Holder: holder, Expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (ml *memoryLease) Release(holder string) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	if ml.state.Holder == holder {
+		ml.state = leaseState{}
+	}
+	return nil
+}
+
+// fileLease is a leaseBackend kept in a file, for instances that share a
+// filesystem.  Changes to the lease file are serialized through a separate
+// lock file, created exclusively.
+type fileLease struct {
+	path string
+}
+
+func (fl fileLease) lock() (func(), error) {
+	lockPath := fl.path + ".lock"
+	deadline := time.Now().Add(leaseLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		// A lock file older than the timeout was left by an instance that
+		// died holding it.
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > leaseLockTimeout {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for lease lock " + lockPath)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (fl fileLease) read() (leaseState, error) {
+	var state leaseState
+	byts, err := ioutil.ReadFile(fl.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if len(byts) == 0 {
+		return state, nil
+	}
+	err = json.Unmarshal(byts, &state)
+	return state, err
+}
+
+func (fl fileLease) write(state leaseState) error {
+	byts, _ := json.Marshal(state)
+	tmpPath := fl.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, byts, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fl.path)
+}
+
+func (fl fileLease) Acquire(holder string, ttl time.Duration) (bool, error) {
+	unlock, err := fl.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	state, err := fl.read()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if !state.grant(holder, now) {
+		return false, nil
+	}
+	return true, fl.write(leaseState{Holder: holder, Expires: now.Add(ttl)})
+}
+
+func (fl fileLease) Release(holder string) error {
+	unlock, err := fl.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := fl.read()
+	if err != nil || state.Holder != holder {
+		return err
+	}
+	return fl.write(leaseState{})
+}
+
+// newLeaseBackend builds the lease backend for the given coordination mode.
+// A blank mode means that no coordination is done, and returns nil.
+func newLeaseBackend(mode, path string) (leaseBackend, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "memory":
+		return &memoryLease{}, nil
+	case "file":
+		if path == "" {
+			return nil, errors.New("file coordination requires COORDINATION_PATH")
+		}
+		return fileLease{path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown coordination mode %q", mode)
+}
+
+// leaseKeeper holds a lease on behalf of this instance.  It renews the lease
+// in the background, every third of leaseTTL, so that the lease cannot lapse
+// while the polling loop sleeps or launches tasks.
+type leaseKeeper struct {
+	backend leaseBackend
+	holder  string
+
+	mu      sync.Mutex
+	validTo time.Time // the time until which this instance can rely on holding the lease
+}
+
+func newLeaseKeeper(backend leaseBackend, holder string) *leaseKeeper {
+	return &leaseKeeper{backend: backend, holder: holder}
+}
+
+// run renews the lease until the process ends
+func (lk *leaseKeeper) run(s pzsvc.Session) {
+	ticker := time.NewTicker(leaseTTL / 3)
+	for {
+		lk.renew(s)
+		<-ticker.C
+	}
+}
+
+// renew acquires or renews the lease.  It is only relied upon for half of its
+// TTL, leaving a margin for clock skew between instances, and for renewals
+// that take a while to go through.
+func (lk *leaseKeeper) renew(s pzsvc.Session) {
+	requested := time.Now()
+	held, err := lk.backend.Acquire(lk.holder, leaseTTL)
+	if err != nil {
+		pzsvc.LogSimpleErr(s, "Could not acquire coordination lease: ", err)
+	}
+
+	lk.mu.Lock()
+	wasHeld := time.Now().Before(lk.validTo)
+	if held {
+		lk.validTo = requested.Add(leaseTTL / 2)
+	} else if err == nil {
+		lk.validTo = time.Time{}
+	}
+	lk.mu.Unlock()
+
+	if held != wasHeld && (held || err == nil) {
+		pzsvc.LogInfo(s, fmt.Sprintf("Instance %s coordination lease held: %t", lk.holder, held))
+	}
+}
+
+// Held returns true if this instance can rely on holding the lease
+func (lk *leaseKeeper) Held() bool {
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+	return time.Now().Before(lk.validTo)
+}
+
+// instanceID returns a name for this dispatcher instance that is unique
+// among its peers
+func instanceID() string {
+	if guid := os.Getenv("CF_INSTANCE_GUID"); guid != "" {
+		return guid
+	}
+	hostName, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostName, os.Getpid())
+}
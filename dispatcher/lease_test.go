@@ -0,0 +1,110 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+func testLeaseBackend(t *testing.T, name string, lease leaseBackend) {
+	held, err := lease.Acquire("one", time.Minute)
+	if err != nil || !held {
+		t.Error(name + `: first holder could not acquire free lease.`)
+	}
+	held, err = lease.Acquire("two", time.Minute)
+	if err != nil || held {
+		t.Error(name + `: second holder acquired held lease.`)
+	}
+	held, err = lease.Acquire("one", time.Minute)
+	if err != nil || !held {
+		t.Error(name + `: holder could not renew its own lease.`)
+	}
+	if err = lease.Release("two"); err != nil {
+		t.Error(name + `: error on release by non-holder: ` + err.Error())
+	}
+	held, _ = lease.Acquire("two", time.Minute)
+	if held {
+		t.Error(name + `: non-holder release freed the lease.`)
+	}
+	if err = lease.Release("one"); err != nil {
+		t.Error(name + `: error on release: ` + err.Error())
+	}
+	held, err = lease.Acquire("two", time.Nanosecond)
+	if err != nil || !held {
+		t.Error(name + `: could not acquire released lease.`)
+	}
+	time.Sleep(time.Millisecond)
+	held, err = lease.Acquire("one", time.Minute)
+	if err != nil || !held {
+		t.Error(name + `: could not acquire expired lease.`)
+	}
+}
+
+func TestMemoryLease(t *testing.T) {
+	testLeaseBackend(t, "TestMemoryLease", &memoryLease{})
+}
+
+func TestFileLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease")
+	if err != nil {
+		t.Fatal(`TestFileLease: could not create temp dir: ` + err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	lease, err := newLeaseBackend("file", filepath.Join(dir, "lease.json"))
+	if err != nil {
+		t.Fatal(`TestFileLease: could not create lease: ` + err.Error())
+	}
+	testLeaseBackend(t, "TestFileLease", lease)
+
+	if _, err = newLeaseBackend("file", ""); err == nil {
+		t.Error(`TestFileLease: accepted file lease without a path.`)
+	}
+}
+
+func TestLeaseKeeper(t *testing.T) {
+	s := pzsvc.Session{AppName: "testApp"}
+	lease := &memoryLease{}
+	one := newLeaseKeeper(lease, "one")
+	two := newLeaseKeeper(lease, "two")
+
+	one.renew(s)
+	two.renew(s)
+	if !one.Held() || two.Held() {
+		t.Fatalf(`TestLeaseKeeper: expected only the first keeper to hold the lease, got %t and %t.`, one.Held(), two.Held())
+	}
+
+	// A keeper relies on its lease for less than the TTL, so that it stops
+	// working before another instance could take over
+	one.mu.Lock()
+	validFor := time.Until(one.validTo)
+	one.mu.Unlock()
+	if validFor <= 0 || validFor > leaseTTL/2 {
+		t.Errorf(`TestLeaseKeeper: lease relied upon for %v.`, validFor)
+	}
+
+	lease.Release("one")
+	two.renew(s)
+	one.renew(s)
+	if one.Held() || !two.Held() {
+		t.Errorf(`TestLeaseKeeper: expected the lease to pass to the second keeper, got %t and %t.`, one.Held(), two.Held())
+	}
+}
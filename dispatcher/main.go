@@ -15,12 +15,13 @@
 package main
 
 import (
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -35,86 +36,60 @@ func main() {
 	pzsvc.LogAudit(s, s.AppName, "startup", s.AppName, "", pzsvc.INFO)
 
 	if len(os.Args) < 2 {
-		pzsvc.LogSimpleErr(s, "error: Insufficient parameters.  You must specify a config file.", nil)
+		pzsvc.LogSimpleErr(s, "error: Insufficient parameters.  You must specify at least one config file or directory.", nil)
 		os.Exit(1)
 	}
 
-	// First argument after the base call should be the path to the config file.
-	// ReadFile returns the contents of the file as a byte buffer.
-	configPath := os.Args[1]
-	configBuf, err := ioutil.ReadFile(configPath)
+	// Every argument after the base call should be the path to a config file,
+	// or to a directory of config files.
+	configPaths, err := expandConfigPaths(os.Args[1:])
 	if err != nil {
-		pzsvc.LogSimpleErr(s, "Dispatcher error in reading config: ", err)
+		pzsvc.LogSimpleErr(s, "Dispatcher error in finding configs: ", err)
 		return
 	}
-	var configObj pzsvc.Config
-	err = json.Unmarshal(configBuf, &configObj)
-	if err != nil {
-		pzsvc.LogSimpleErr(s, "Dispatcher error in unmarshalling config: ", err)
-		return
-	}
-
-	s.LogAudit = configObj.LogAudit
-	if configObj.LogAudit {
-		pzsvc.LogInfo(s, "Config: Audit logging enabled.")
-	} else {
-		pzsvc.LogInfo(s, "Config: Audit logging disabled.")
-	}
 
-	s.PzAddr = configObj.PzAddr
-	if configObj.PzAddrEnVar != "" {
-		newAddr := os.Getenv(configObj.PzAddrEnVar)
-		if newAddr != "" {
-			s.PzAddr = newAddr
+	stores := map[string]*stateStore{}
+	services := []*dispatchService{}
+	for _, configPath := range configPaths {
+		svc, err := newDispatchService(s, configPath, stores)
+		if err == errBadClassification {
+			pzsvc.LogSimpleErr(s, "Config "+configPath+" has an invalid classification.  The application cannot start.", nil)
+			os.Exit(1)
 		}
+		if err != nil {
+			pzsvc.LogInfo(s, "Skipping config "+configPath+".")
+			continue
+		}
+		services = append(services, svc)
 	}
-	if s.PzAddr == "" {
-		pzsvc.LogSimpleErr(s, "Config: Cannot work tasks.  Must have either a valid PzAddr, or a valid and populated PzAddrEnVar.", nil)
-		return
-	}
-
-	if configObj.SvcName == "" {
-		pzsvc.LogSimpleErr(s, "Config: Cannot work tasks without service name.", nil)
-		return
-	}
-
-	if configObj.APIKeyEnVar == "" {
-		pzsvc.LogSimpleErr(s, "Config: Cannot work tasks without valid APIKeyEnVar.", nil)
-		return
-	}
-	apiKey := os.Getenv(configObj.APIKeyEnVar)
-	if apiKey == "" {
-		pzsvc.LogSimpleErr(s, "No API key at APIKeyEnVar.  Cannot work.", nil)
+	if len(services) == 0 {
+		pzsvc.LogSimpleErr(s, "No usable service configs found.  The application cannot start.", nil)
 		return
 	}
-	s.PzAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(apiKey+":"))
 
-	// Check for the Service ID. If it exists, then grab the ID. If it doesn't exist, then Register it.
-	svcID, err := pzsvc.FindMySvc(s, configObj.SvcName)
-	if err != nil {
-		pzsvc.LogSimpleErr(s, "Dispatcher could not find Piazza Service ID.  Initial Error: ", err)
-		return
+	// Logging, audit sinks and tracing are process-wide, and so are set up
+	// from the first config that asks for each
+	for _, svc := range services {
+		if svc.config.LogFormat != "" || svc.config.LogLevel != "" {
+			if err := pzsvc.SetupLogging(svc.config); err != nil {
+				pzsvc.LogSimpleErr(s, "Config: could not set up logging: ", err)
+			}
+			break
+		}
 	}
-	if svcID == "" {
-		// If no Service ID is found, attempt to register it.
-		pzsvc.LogInfo(s, "Could not find service.  Will attempt to register it.")
-		_, s := pzsvc.ParseConfigAndRegister(s, &configObj)
-
-		// With registration completed, Check back for Service ID
-		time.Sleep(time.Duration(1) * time.Second)
-		svcID, err := pzsvc.FindMySvc(s, configObj.SvcName)
-		if err != nil {
-			pzsvc.LogSimpleErr(s, "Dispatcher could not find new Service ID post registration.  Initial Error: ", err)
-			return
+	for _, svc := range services {
+		if len(svc.config.AuditSinks) > 0 {
+			pzsvc.SetupAuditSinks(s, svc.config)
+			break
 		}
-		if svcID == "" {
-			pzsvc.LogInfo(s, "Could not find service ID post registration. The application cannot start. Please verify Service Registration and restart the application.")
-			return
+	}
+	for _, svc := range services {
+		if svc.config.TraceExporter != "" {
+			pzsvc.SetupTracing(s, s.AppName, svc.config)
+			break
 		}
 	}
 
-	pzsvc.LogInfo(s, "Found target service.  ServiceID: "+svcID+".")
-
 	// Initialize the CF Client
 	clientConfig := &cfclient.Config{
 		ApiAddress: os.Getenv("CF_API"),
@@ -127,9 +102,73 @@ func main() {
 		return
 	}
 
+	lease, err := newLeaseBackend(os.Getenv("COORDINATION"), os.Getenv("COORDINATION_PATH"))
+	if err != nil {
+		pzsvc.LogSimpleErr(s, "Dispatcher could not set up coordination: ", err)
+		return
+	}
+
+	appID, err := readAppID(s)
+	if err != nil {
+		return
+	}
+
+	// Read the # of seconds the polling loop may go without a pass before the watchdog kills the Dispatcher
+	stallTimeout := defaultStallTimeout
+	if envStallTimeout := os.Getenv("POLL_STALL_TIMEOUT"); envStallTimeout != "" {
+		if seconds, err := strconv.Atoi(envStallTimeout); err == nil && seconds > 0 {
+			stallTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if floor := minStallTimeout(readPollMaxInterval()); stallTimeout < floor {
+		pzsvc.LogWarn(s, "Config: POLL_STALL_TIMEOUT of "+stallTimeout.String()+" is shorter than the polling loop may legitimately wait.  Using "+floor.String()+".")
+		stallTimeout = floor
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", pzsvc.MetricsHandler)
+	mux.HandleFunc("/healthz", healthzHandler(stallTimeout))
+	mux.HandleFunc("/readyz", readyzHandler(services, client, appID))
+	mux.HandleFunc("/jobs/", cancelHandler(services, client))
+	go serveHTTP(s, mux)
+
 	pzsvc.LogInfo(s, "Cloud Foundry Client initialized. Beginning Polling.")
 
-	pollForJobs(s, configObj, svcID, configPath, client)
+	for _, svc := range services {
+		recoverJobs(svc.s, svc.svcID, client, svc.store, svc.tracker)
+		go reconcileJobs(svc.s, svc.svcID, client, svc.tracker)
+	}
+
+	markPollTick()
+	go watchdog(s, stallTimeout)
+
+	pollForJobs(s, services, client, appID, lease)
+}
+
+// readPollMaxInterval reads the longest time, in seconds, that the Dispatcher
+// may wait between polls while idle
+func readPollMaxInterval() time.Duration {
+	if envPollMax := os.Getenv("POLL_MAX_INTERVAL"); envPollMax != "" {
+		if seconds, err := strconv.Atoi(envPollMax); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultPollInterval
+}
+
+// readAppID reads this application's CF application ID from the environment
+func readAppID(s pzsvc.Session) (string, error) {
+	vcapJSONContainer := make(map[string]interface{})
+	err := json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &vcapJSONContainer)
+	if err != nil {
+		return "", pzsvc.LogSimpleErr(s, "Cannot proceed: Error in reading VCAP Application properties: ", err)
+	}
+	appID, ok := vcapJSONContainer["application_id"].(string)
+	if !ok {
+		return "", pzsvc.LogSimpleErr(s, "Cannot Read Application Name from VCAP Application properties: string type assertion failed", nil)
+	}
+	pzsvc.LogInfo(s, "Found application name from VCAP Tree: "+appID)
+	return appID, nil
 }
 
 // WorkBody exists as part of the response format of the Piazza job manager task request endpoint.
@@ -163,135 +202,349 @@ type WorkOutData struct {
 	SvcData WorkSvcData `json:"serviceData"`
 }
 
-func pollForJobs(s pzsvc.Session, configObj pzsvc.Config, svcID string, configPath string, cfClient *cfclient.Client) {
-	var (
-		err error
-	)
+func pollForJobs(s pzsvc.Session, services []*dispatchService, cfClient *cfclient.Client, appID string, lease leaseBackend) {
 	s.SessionID = "Polling"
 
-	// Get the application name
-	vcapJSONContainer := make(map[string]interface{})
-	err = json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &vcapJSONContainer)
-	if err != nil {
-		pzsvc.LogSimpleErr(s, "Cannot proceed: Error in reading VCAP Application properties: ", err)
-		return
-	}
-	appID, ok := vcapJSONContainer["application_id"].(string)
-	if !ok {
-		pzsvc.LogSimpleErr(s, "Cannot Read Application Name from VCAP Application properties: string type assertion failed", nil)
-		return
-	}
-	pzsvc.LogInfo(s, "Found application name from VCAP Tree: "+appID)
-
 	// Read the # of simultaneous Tasks that are allowed to be run by the Dispatcher
 	taskLimit := 5
 	if envTaskLimit := os.Getenv("TASK_LIMIT"); envTaskLimit != "" {
 		taskLimit, _ = strconv.Atoi(envTaskLimit)
 	}
+	taskLimitGauge.Set(float64(taskLimit))
+
+	backoff := newPollBackoff(minPollInterval, readPollMaxInterval())
+
+	var leader *leaseKeeper
+	if lease != nil {
+		leader = newLeaseKeeper(lease, instanceID())
+		go leader.run(s)
+	}
+
+	// Polling Loop.  Each pass offers every service with room a chance at one
+	// task, starting from a different service each time so that none of them
+	// gets first pick of the shared task limit every time.
+	for start := 0; ; start = (start + 1) % len(services) {
+		markPollTick()
+
+		// Queued jobs expire whether or not there is room to launch them, and
+		// whether or not this instance holds the lease
+		for _, svc := range services {
+			expireQueued(svc)
+		}
+
+		// When coordinating with other instances, only the lease holder works.
+		// Jobs prefetched before the lease was lost cannot be handed to the
+		// new holder, so they are failed rather than left to go stale here.
+		if leader != nil && !leader.Held() {
+			for _, svc := range services {
+				failQueued(svc, svc.queue.Drain(), "dropped when the dispatcher lost its coordination lease")
+			}
+			time.Sleep(leaseTTL / 4)
+			continue
+		}
 
-	// Polling Loop
-	for {
 		// First, check to see if there is room for tasks. If we've reached the task limit, then do not poll Piazza for jobs.
 		query := url.Values{}
 		query.Add("states", "RUNNING")
 		tasks, err := cfClient.TasksByAppByQuery(appID, query)
 		if err != nil {
 			pzsvc.LogSimpleErr(s, "Cannot poll CF tasks", err)
+			time.Sleep(backoff.Next())
+			continue
 		}
 
-		if len(tasks) > taskLimit {
-			pzsvc.LogInfo(s, "Maximum Tasks reached for App. Will not poll for work until current work has completed.")
-			continue
+		running := len(tasks)
+		runningBySvc := make([]int, len(services))
+		for _, task := range tasks {
+			for i, svc := range services {
+				if svc.tracker.Has(task.Name) {
+					runningBySvc[i]++
+					break
+				}
+			}
+		}
+		tasksRunning.Set(float64(running))
+		for i, svc := range services {
+			tasksRunningByService.Set(float64(runningBySvc[i]), svc.config.SvcName)
 		}
 
-		var pzJobObj struct {
-			Data WorkOutData `json:"data"`
+		launched := 0
+		hasRoom := false
+		for i := range services {
+			if running >= taskLimit {
+				pzsvc.LogInfo(s, "Maximum Tasks reached for App. Will not poll for work until current work has completed.")
+				break
+			}
+			svcIndex := (start + i) % len(services)
+			svc := services[svcIndex]
+			if !svc.hasCapacity(runningBySvc[svcIndex]) {
+				continue
+			}
+			hasRoom = true
+			if launchNext(svc, appID, cfClient, leader) {
+				launched++
+				running++
+				runningBySvc[svcIndex]++
+			}
 		}
-		pzJobObj.Data = WorkOutData{SvcData: WorkSvcData{JobID: "", Data: WorkInData{DataInputs: WorkDataInputs{Body: WorkBody{Content: ""}}}}}
 
-		byts, pErr := pzsvc.RequestKnownJSON("POST", "", s.PzAddr+"/service/"+svcID+"/task", s.PzAuth, &pzJobObj)
-		if pErr != nil {
-			pErr.Log(s, "Dispatcher: error getting new task:"+string(byts))
-			time.Sleep(time.Duration(5) * time.Second)
+		// While there is work to be had and room to run it, go straight back
+		// for more.  With no room, check again shortly for tasks that have
+		// finished.  Otherwise, back off until something changes.
+		if launched > 0 {
+			backoff.Reset()
+			if running < taskLimit {
+				continue
+			}
+		}
+		if running >= taskLimit || !hasRoom {
+			time.Sleep(capacityPollInterval)
 			continue
 		}
+		time.Sleep(backoff.Next())
+	}
+}
 
-		inpStr := pzJobObj.Data.SvcData.Data.DataInputs.Body.Content
-		jobID := pzJobObj.Data.SvcData.JobID
-		if inpStr != "" {
-			pzsvc.LogInfo(s, "New Task Grabbed.  JobID: "+jobID)
+// expireQueued fails every job in the given service's queue that has waited
+// too long to be launched.  Piazza starts the clock on MaxRunTime when we pull
+// the job, so anything that has sat in the queue the whole time is already
+// lost.
+func expireQueued(svc *dispatchService) {
+	maxAge := time.Duration(svc.config.MaxRunTime) * time.Second
+	failQueued(svc, svc.queue.Expired(time.Now(), maxAge), "expired in dispatcher queue")
+}
 
-			var jobInputContent pzsvc.InpStruct
-			var displayByt []byte
-			err = json.Unmarshal([]byte(inpStr), &jobInputContent)
-			if err == nil {
-				if jobInputContent.ExtAuth != "" {
-					jobInputContent.ExtAuth = "*****"
-				}
-				if jobInputContent.PzAuth != "" {
-					jobInputContent.PzAuth = "*****"
-				}
-				displayByt, err = json.Marshal(jobInputContent)
-				if err != nil {
-					pzsvc.LogAudit(s, s.UserID, "Audit failure", s.AppName, "Could not Marshal.  Job Canceled.", pzsvc.ERROR)
-					pzsvc.SendExecResultNoData(s, s.PzAddr, svcID, jobID, pzsvc.PiazzaStatusFail)
-					time.Sleep(5 * time.Second)
-					continue
-				}
-			}
+// failQueued reports the given jobs, taken from the service's queue, to
+// Piazza as failed, and forgets them.  The reason completes "Job ...".
+func failQueued(svc *dispatchService, jobs []*queuedJob, reason string) {
+	s := svc.s
+	for _, job := range jobs {
+		pzsvc.LogAudit(s, job.UserID, "Audit failure", s.AppName, "Job "+job.JobID+" "+reason+".  Job Failed.", pzsvc.ERROR)
+		failedSession := s
+		failedSession.Span = job.Span
+		pzsvc.SendExecResultError(failedSession, s.PzAddr, svc.svcID, job.JobID, pzsvc.PiazzaStatusFail, "Job "+reason+" before a task could be launched")
+		svc.store.JobDone(job.JobID)
+		job.Span.SetError(errors.New("job " + reason))
+		job.Span.End()
+	}
+}
 
-			// Form the CLI for the Algorithm Task
-			workerCommand := fmt.Sprintf("worker --cliExtra '%s' --userID '%s' --config '%s' --serviceID '%s' --output '%s' --jobID '%s'", jobInputContent.Command, jobInputContent.UserID, configPath, svcID, jobInputContent.OutGeoJs[0], jobID)
-			// For each input image, add that image ref as an argument to the CLI.
-			// If AWS images, track the total file size to appropriately size the PCF task container.
-			var fileSizeTotal int
-			for i := range jobInputContent.InExtFiles {
-				workerCommand += fmt.Sprintf(" -i '%s:%s'", jobInputContent.InExtNames[i], jobInputContent.InExtFiles[i])
-				if strings.Contains(jobInputContent.InExtFiles[i], "amazonaws") {
-					fileSize, err := pzsvc.GetS3FileSizeInMegabytes(jobInputContent.InExtFiles[i])
-					if err == nil {
-						pzsvc.LogInfo(s, fmt.Sprintf("S3 File Size for %s found to be %d", jobInputContent.InExtFiles[i], fileSize))
-						fileSizeTotal += fileSize
-					} else {
-						err.Log(s, "Tried to get File Size from S3 File "+jobInputContent.InExtFiles[i]+" but encountered an error.")
-					}
-				}
-			}
-			diskInMegabyte := 6142
-			if fileSizeTotal != 0 {
-				// Allocate 2G for the filesystem and executables (with some buffer), then add the image sizes
-				diskInMegabyte = 2048 + fileSizeTotal
-				pzsvc.LogInfo(s, fmt.Sprintf("Obtained S3 File Sizes for input files; will use Dynamic Disk Space of %d in Task container.", diskInMegabyte))
-			} else {
-				pzsvc.LogInfo(s, "Could not get the S3 File Sizes for input files. Will use the default Disk Space when running Task.")
-			}
+// launchNext tops up the given service's queue of prefetched jobs from Piazza,
+// and then launches the next in line, if this instance still holds the
+// lease.  Returns true if a task was launched.
+func launchNext(svc *dispatchService, appID string, cfClient *cfclient.Client, leader *leaseKeeper) bool {
+	if leader != nil && !leader.Held() {
+		return false
+	}
+	for svc.queue.Len() < svc.prefetchLimit() {
+		job := fetchJob(svc, appID)
+		if job == nil {
+			break
+		}
+		svc.queue.Push(job)
+	}
 
-			taskRequest := cfclient.TaskRequest{
-				Command:          workerCommand,
-				Name:             jobID,
-				DropletGUID:      appID,
-				MemoryInMegabyte: 3072,
-				DiskInMegabyte:   diskInMegabyte,
-			}
+	// The lease may have been lost while fetching jobs.  Any jobs fetched
+	// are failed on the next pass.
+	if leader != nil && !leader.Held() {
+		return false
+	}
+	maxAge := time.Duration(svc.config.MaxRunTime) * time.Second
+	job := svc.queue.Next(time.Now(), maxAge/2, svc.tracker.RunningByUser())
+	if job == nil {
+		return false
+	}
+	return launchJob(svc, cfClient, job)
+}
 
-			pzsvc.LogAudit(s, s.UserID, "Creating CF Task for Job "+jobID+" : "+workerCommand, s.AppName, string(displayByt), pzsvc.INFO)
+// fetchJob asks Piazza for a single task for the given service, and prepares
+// the CF task request to work it.  Returns nil if there was no job to be had.
+func fetchJob(svc *dispatchService, appID string) *queuedJob {
+	s := svc.s
+	svcID := svc.svcID
 
-			// Send Run-Task request to CF
-			_, err := cfClient.CreateTask(taskRequest)
-			if err != nil {
-				pzsvc.LogAudit(s, s.UserID, "Audit failure", s.AppName, "Could not Create PCF Task for Job. Job Failed: "+err.Error(), pzsvc.ERROR)
-				pzsvc.SendExecResultNoData(s, s.PzAddr, svcID, jobID, pzsvc.PiazzaStatusFail)
-				time.Sleep(5 * time.Second)
-				continue
-			}
+	var pzJobObj struct {
+		Data WorkOutData `json:"data"`
+	}
+	pzJobObj.Data = WorkOutData{SvcData: WorkSvcData{JobID: "", Data: WorkInData{DataInputs: WorkDataInputs{Body: WorkBody{Content: ""}}}}}
+
+	pollsTotal.Inc(svc.config.SvcName)
+	byts, pErr := pzsvc.RequestKnownJSON("POST", "", s.PzAddr+"/service/"+svcID+"/task", s.PzAuth, &pzJobObj)
+	if pErr != nil {
+		pErr.Log(s, "Dispatcher: error getting new task:"+string(byts))
+		return nil
+	}
+
+	inpStr := pzJobObj.Data.SvcData.Data.DataInputs.Body.Content
+	jobID := pzJobObj.Data.SvcData.JobID
+	if inpStr == "" {
+		// This is way too chatty. I don't think it's needed at this point in time.
+		// pzsvc.LogInfo(s, "No Jobs found during Poll; Trying again shortly.")
+		return nil
+	}
+	s = s.WithField("jobID", jobID)
+	pzsvc.LogInfo(s, "New Task Grabbed.  JobID: "+jobID)
+	tasksGrabbedTotal.Inc(svc.config.SvcName)
+
+	// The job's trace begins here, and is carried on to the worker
+	span := pzsvc.StartSpan(nil, "dispatch job")
+	span.SetAttribute("job.id", jobID)
+	span.SetAttribute("service.id", svcID)
+	span.SetAttribute("service.name", svc.config.SvcName)
+	s.Span = span
+
+	var jobInputContent pzsvc.InpStruct
+	var displayByt []byte
+	err := json.Unmarshal([]byte(inpStr), &jobInputContent)
+	if err != nil {
+		pzsvc.LogAudit(s, s.UserID, "Job rejected", s.AppName, "Job input is not valid JSON: "+err.Error()+".  Job Canceled.", pzsvc.ERROR)
+		pzsvc.SendExecResultError(s, s.PzAddr, svcID, jobID, pzsvc.PiazzaStatusFail, "Job input is not valid JSON: "+err.Error())
+		span.SetError(err)
+		span.End()
+		return nil
+	}
+	if jobInputContent.ExtAuth != "" {
+		jobInputContent.ExtAuth = "*****"
+	}
+	if jobInputContent.PzAuth != "" {
+		jobInputContent.PzAuth = "*****"
+	}
+	displayByt, err = json.Marshal(jobInputContent)
+	if err != nil {
+		pzsvc.LogAudit(s, s.UserID, "Audit failure", s.AppName, "Could not Marshal.  Job Canceled.", pzsvc.ERROR)
+		pzsvc.SendExecResultNoData(s, s.PzAddr, svcID, jobID, pzsvc.PiazzaStatusFail)
+		span.SetError(err)
+		span.End()
+		return nil
+	}
 
-			pzsvc.LogAudit(s, s.UserID, "Task Created for CF Job", s.AppName, string(displayByt), pzsvc.INFO)
+	outputs := []pzsvc.OutFile{}
+	for _, outFiles := range [][]string{jobInputContent.OutGeoJs, jobInputContent.OutTiffs, jobInputContent.OutTxts, jobInputContent.OutSpecs} {
+		for _, name := range outFiles {
+			outputs = append(outputs, pzsvc.OutFile{Name: name})
+		}
+	}
+	outputs = append(outputs, jobInputContent.Outputs...)
+	if len(outputs) == 0 {
+		pzsvc.LogAudit(s, s.UserID, "Job rejected", s.AppName, "Job names no outputs.  Job Canceled.", pzsvc.ERROR)
+		pzsvc.SendExecResultError(s, s.PzAddr, svcID, jobID, pzsvc.PiazzaStatusFail, "Job names no output files")
+		span.SetError(errors.New("job names no outputs"))
+		span.End()
+		return nil
+	}
 
-			time.Sleep(5 * time.Second)
-		} else {
-			// This is way too chatty. I don't think it's needed at this point in time.
-			// pzsvc.LogInfo(s, "No Jobs found during Poll; Trying again shortly.")
-			time.Sleep(5 * time.Second)
+	class, err := pzsvc.JobClassification(svc.config, jobInputContent.Class)
+	if err != nil {
+		pzsvc.LogAudit(s, s.UserID, "Job rejected", s.AppName, "Job has an invalid classification: "+err.Error()+".  Job Canceled.", pzsvc.ERROR)
+		pzsvc.SendExecResultError(s, s.PzAddr, svcID, jobID, pzsvc.PiazzaStatusFail, "Invalid classification: "+err.Error())
+		span.SetError(err)
+		span.End()
+		return nil
+	}
+	span.SetAttribute("job.classification", class)
+
+	// Form the CLI for the Algorithm Task
+	// Every value that comes from the job is quoted, as the task command is run by a shell
+	workerCommand := "worker --cliExtra " + shellQuote(jobInputContent.Command) +
+		" --userID " + shellQuote(jobInputContent.UserID) +
+		" --config " + shellQuote(svc.configPath) +
+		" --serviceID " + shellQuote(svcID) +
+		" --jobID " + shellQuote(jobID)
+	for _, output := range outputs {
+		workerCommand += " --output " + shellQuote(output.Name)
+		if output.Deploy {
+			workerCommand += " --deploy " + shellQuote(output.Name)
+		}
+	}
+	attributeKeys := make([]string, 0, len(jobInputContent.Attributes))
+	for key := range jobInputContent.Attributes {
+		attributeKeys = append(attributeKeys, key)
+	}
+	sort.Strings(attributeKeys)
+	for _, key := range attributeKeys {
+		workerCommand += " --attribute " + shellQuote(key+"="+jobInputContent.Attributes[key])
+	}
+	workerCommand += " --classification " + shellQuote(class)
+	workerCommand += " --traceparent " + shellQuote(span.TraceParent())
+	span.SetAttribute("user.id", jobInputContent.UserID)
+	// For each input image, add that image ref as an argument to the CLI.
+	// If AWS images, track the total file size to appropriately size the PCF task container.
+	var fileSizeTotal int
+	for i := range jobInputContent.InExtFiles {
+		workerCommand += " -i " + shellQuote(jobInputContent.InExtNames[i]+":"+jobInputContent.InExtFiles[i])
+		if strings.Contains(jobInputContent.InExtFiles[i], "amazonaws") {
+			fileSize, err := pzsvc.GetS3FileSizeInMegabytes(jobInputContent.InExtFiles[i])
+			if err == nil {
+				pzsvc.LogInfo(s, fmt.Sprintf("S3 File Size for %s found to be %d", jobInputContent.InExtFiles[i], fileSize))
+				fileSizeTotal += fileSize
+			} else {
+				err.Log(s, "Tried to get File Size from S3 File "+jobInputContent.InExtFiles[i]+" but encountered an error.")
+			}
 		}
 	}
+	diskInMegabyte := 6142
+	if fileSizeTotal != 0 {
+		// Allocate 2G for the filesystem and executables (with some buffer), then add the image sizes
+		diskInMegabyte = 2048 + fileSizeTotal
+		pzsvc.LogInfo(s, fmt.Sprintf("Obtained S3 File Sizes for input files; will use Dynamic Disk Space of %d in Task container.", diskInMegabyte))
+	} else {
+		pzsvc.LogInfo(s, "Could not get the S3 File Sizes for input files. Will use the default Disk Space when running Task.")
+	}
+
+	job := &queuedJob{
+		JobID:    jobID,
+		UserID:   jobInputContent.UserID,
+		Priority: jobInputContent.Priority,
+		TaskRequest: cfclient.TaskRequest{
+			Command:          workerCommand,
+			Name:             jobID,
+			DropletGUID:      appID,
+			MemoryInMegabyte: 3072,
+			DiskInMegabyte:   diskInMegabyte,
+		},
+		DisplayJSON: string(displayByt),
+		GrabbedAt:   time.Now(),
+		Span:        span,
+	}
+	svc.store.JobGrabbed(svcID, jobID, job.UserID, job.TaskRequest)
+
+	return job
+}
+
+// launchJob sends the run-task request for the given job to CF.  Returns
+// true if the task was created.
+func launchJob(svc *dispatchService, cfClient *cfclient.Client, job *queuedJob) bool {
+	s := svc.s.WithField("jobID", job.JobID)
+	s.Span = job.Span
+	svcID := svc.svcID
+	jobID := job.JobID
+	defer job.Span.End()
+
+	pzsvc.LogAudit(s, s.UserID, "Creating CF Task for Job "+jobID+" : "+job.TaskRequest.Command, s.AppName, job.DisplayJSON, pzsvc.INFO)
+
+	// Send Run-Task request to CF
+	taskSpan := pzsvc.StartSpan(job.Span, "create CF task")
+	task, err := cfClient.CreateTask(job.TaskRequest)
+	taskSpan.SetError(err)
+	taskSpan.End()
+	if err != nil {
+		job.Span.SetError(err)
+		pzsvc.LogAudit(s, s.UserID, "Audit failure", s.AppName, "Could not Create PCF Task for Job. Job Failed: "+err.Error(), pzsvc.ERROR)
+		pzsvc.SendExecResultNoData(s, s.PzAddr, svcID, jobID, pzsvc.PiazzaStatusFail)
+		svc.store.JobDone(jobID)
+		launchFailuresTotal.Inc(svc.config.SvcName)
+		return false
+	}
+
+	pzsvc.LogAudit(s, s.UserID, "Task Created for CF Job", s.AppName, job.DisplayJSON, pzsvc.INFO)
+	job.Span.SetAttribute("cf.task.guid", task.GUID)
+	tasksLaunchedTotal.Inc(svc.config.SvcName)
+	svc.tracker.Track(jobID, job.UserID, task.GUID)
+
+	return true
+}
+
+// shellQuote quotes a string as a single shell word
+func shellQuote(word string) string {
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
 }
@@ -0,0 +1,33 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	words := []string{"", "plain", "two words", "it's", `'; rm -rf / #`, `$(id) "x" \n`}
+	for _, word := range words {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(word)).Output()
+		if err != nil {
+			t.Fatalf(`TestShellQuote: shell error for "%s": %s`, word, err.Error())
+		}
+		if string(out) != word {
+			t.Errorf(`TestShellQuote: "%s" came through the shell as "%s"`, word, out)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "github.com/venicegeo/pzsvc-exec/pzsvc"
+
+var (
+	pollsTotal = pzsvc.NewCounter("dispatcher_polls_total",
+		"Requests made to Piazza for new tasks.", "service")
+	tasksGrabbedTotal = pzsvc.NewCounter("dispatcher_tasks_grabbed_total",
+		"Tasks received from Piazza.", "service")
+	tasksLaunchedTotal = pzsvc.NewCounter("dispatcher_tasks_launched_total",
+		"CF tasks created for Piazza jobs.", "service")
+	launchFailuresTotal = pzsvc.NewCounter("dispatcher_launch_failures_total",
+		"Piazza jobs failed because a CF task could not be created for them.", "service")
+	tasksRunning = pzsvc.NewGauge("dispatcher_tasks_running",
+		"CF tasks currently running for the dispatcher app.")
+	tasksRunningByService = pzsvc.NewGauge("dispatcher_service_tasks_running",
+		"CF tasks currently running for each service.", "service")
+	taskLimitGauge = pzsvc.NewGauge("dispatcher_task_limit",
+		"Maximum number of CF tasks the dispatcher will run at once.")
+	stateJournalErrorsTotal = pzsvc.NewCounter("dispatcher_state_journal_errors_total",
+		"Failures to write or compact the dispatcher state journal.")
+)
@@ -0,0 +1,120 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+// queuedJob is a job that has been pulled from Piazza, and is waiting for
+// the dispatcher to launch a task for it
+type queuedJob struct {
+	JobID       string
+	UserID      string
+	Priority    int
+	TaskRequest cfclient.TaskRequest
+	DisplayJSON string // the job input, with auth keys masked, for audit logging
+	GrabbedAt   time.Time
+	Span        *pzsvc.Span // the span covering the job's time in the dispatcher
+}
+
+// jobQueue holds the jobs prefetched for a single service.  It is only ever
+// touched from the polling loop, and so does no locking of its own.
+type jobQueue struct {
+	jobs []*queuedJob
+}
+
+// Len returns the number of jobs waiting in the queue
+func (q *jobQueue) Len() int {
+	return len(q.jobs)
+}
+
+// Push adds a job to the back of the queue
+func (q *jobQueue) Push(job *queuedJob) {
+	q.jobs = append(q.jobs, job)
+}
+
+// Expired removes and returns every job that has been waiting longer than
+// maxAge.  A maxAge of zero means that jobs never expire.
+func (q *jobQueue) Expired(now time.Time, maxAge time.Duration) []*queuedJob {
+	if maxAge <= 0 {
+		return nil
+	}
+	expired := []*queuedJob{}
+	kept := q.jobs[:0]
+	for _, job := range q.jobs {
+		if now.Sub(job.GrabbedAt) > maxAge {
+			expired = append(expired, job)
+		} else {
+			kept = append(kept, job)
+		}
+	}
+	q.jobs = kept
+	return expired
+}
+
+// Drain removes and returns every job in the queue
+func (q *jobQueue) Drain() []*queuedJob {
+	drained := q.jobs
+	q.jobs = nil
+	return drained
+}
+
+// Next removes and returns the job that should be launched next, or nil if
+// the queue is empty.  Jobs that have waited longer than urgentAge go first,
+// oldest first, so that they still have time to finish within Piazza's
+// timeout.  After that, the highest priority wins, and within a priority the
+// job of the user with the fewest tasks running wins.  Remaining ties go to
+// whichever job was pulled first.
+func (q *jobQueue) Next(now time.Time, urgentAge time.Duration, runningByUser map[string]int) *queuedJob {
+	if len(q.jobs) == 0 {
+		return nil
+	}
+
+	best := 0
+	for i := 1; i < len(q.jobs); i++ {
+		if q.before(q.jobs[i], q.jobs[best], now, urgentAge, runningByUser) {
+			best = i
+		}
+	}
+
+	job := q.jobs[best]
+	q.jobs = append(q.jobs[:best], q.jobs[best+1:]...)
+	return job
+}
+
+// before returns true if job a should be launched ahead of job b
+func (q *jobQueue) before(a, b *queuedJob, now time.Time, urgentAge time.Duration, runningByUser map[string]int) bool {
+	if urgentAge > 0 {
+		aUrgent := now.Sub(a.GrabbedAt) > urgentAge
+		bUrgent := now.Sub(b.GrabbedAt) > urgentAge
+		if aUrgent != bUrgent {
+			return aUrgent
+		}
+		if aUrgent {
+			return a.GrabbedAt.Before(b.GrabbedAt)
+		}
+	}
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if runningByUser[a.UserID] != runningByUser[b.UserID] {
+		return runningByUser[a.UserID] < runningByUser[b.UserID]
+	}
+	return a.GrabbedAt.Before(b.GrabbedAt)
+}
@@ -0,0 +1,97 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func queueOf(jobs ...*queuedJob) *jobQueue {
+	q := &jobQueue{}
+	for _, job := range jobs {
+		q.Push(job)
+	}
+	return q
+}
+
+func TestJobQueueNext(t *testing.T) {
+	now := time.Now()
+	old := &queuedJob{JobID: "old", GrabbedAt: now.Add(-time.Hour)}
+	older := &queuedJob{JobID: "older", GrabbedAt: now.Add(-2 * time.Hour)}
+	high := &queuedJob{JobID: "high", Priority: 5, GrabbedAt: now}
+	busyUser := &queuedJob{JobID: "busy", UserID: "busy", GrabbedAt: now.Add(-time.Minute)}
+	idleUser := &queuedJob{JobID: "idle", UserID: "idle", GrabbedAt: now}
+	first := &queuedJob{JobID: "first", GrabbedAt: now.Add(-time.Second)}
+	second := &queuedJob{JobID: "second", GrabbedAt: now}
+	running := map[string]int{"busy": 2}
+
+	testCases := []struct {
+		name      string
+		queue     *jobQueue
+		urgentAge time.Duration
+		expected  []string
+	}{
+		{"urgent first, oldest first", queueOf(high, old, older), 30 * time.Minute, []string{"older", "old", "high"}},
+		{"no urgency", queueOf(old, high), 0, []string{"high", "old"}},
+		{"priority", queueOf(second, high), time.Hour * 3, []string{"high", "second"}},
+		{"fair share", queueOf(busyUser, idleUser), time.Hour, []string{"idle", "busy"}},
+		{"fifo", queueOf(second, first), time.Hour, []string{"first", "second"}},
+	}
+	for _, tc := range testCases {
+		for i, expected := range tc.expected {
+			job := tc.queue.Next(now, tc.urgentAge, running)
+			if job == nil || job.JobID != expected {
+				t.Errorf(`TestJobQueueNext: %s: pick %d was %v, expected %s.`, tc.name, i, job, expected)
+			}
+		}
+		if tc.queue.Next(now, tc.urgentAge, running) != nil || tc.queue.Len() != 0 {
+			t.Errorf(`TestJobQueueNext: %s: queue not emptied.`, tc.name)
+		}
+	}
+}
+
+func TestJobQueueExpired(t *testing.T) {
+	now := time.Now()
+	stale := &queuedJob{JobID: "stale", GrabbedAt: now.Add(-time.Hour)}
+	fresh := &queuedJob{JobID: "fresh", GrabbedAt: now}
+
+	q := queueOf(stale, fresh)
+	if expired := q.Expired(now, 0); len(expired) != 0 || q.Len() != 2 {
+		t.Error(`TestJobQueueExpired: jobs expired with no maximum age.`)
+	}
+	expired := q.Expired(now, time.Minute)
+	if len(expired) != 1 || expired[0].JobID != "stale" {
+		t.Errorf(`TestJobQueueExpired: wrong jobs expired: %v`, expired)
+	}
+	if q.Len() != 1 || q.Next(now, 0, nil).JobID != "fresh" {
+		t.Error(`TestJobQueueExpired: fresh job not kept.`)
+	}
+}
+
+func TestJobQueueDrain(t *testing.T) {
+	now := time.Now()
+	q := queueOf(&queuedJob{JobID: "a", GrabbedAt: now}, &queuedJob{JobID: "b", GrabbedAt: now})
+	drained := q.Drain()
+	if len(drained) != 2 || drained[0].JobID != "a" || drained[1].JobID != "b" {
+		t.Errorf(`TestJobQueueDrain: wrong jobs drained: %v`, drained)
+	}
+	if q.Len() != 0 || q.Next(now, 0, nil) != nil {
+		t.Error(`TestJobQueueDrain: queue not emptied.`)
+	}
+	if len(q.Drain()) != 0 {
+		t.Error(`TestJobQueueDrain: jobs drained twice.`)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+// dispatchService holds everything the dispatcher needs in order to work
+// jobs for a single configured Piazza service
+type dispatchService struct {
+	s          pzsvc.Session
+	config     pzsvc.Config
+	configPath string
+	svcID      string
+	store      *stateStore
+	tracker    *jobTracker
+	queue      jobQueue
+}
+
+// expandConfigPaths turns the dispatcher's arguments into a list of config
+// files.  Each argument may be either a config file or a directory, in which
+// case every regular, non-hidden file in it is taken as a config file.
+func expandConfigPaths(args []string) ([]string, error) {
+	paths := []string{}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		entries, err := ioutil.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		dirPaths := []string{}
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				dirPaths = append(dirPaths, filepath.Join(arg, entry.Name()))
+			}
+		}
+		sort.Strings(dirPaths)
+		paths = append(paths, dirPaths...)
+	}
+	return paths, nil
+}
+
+// errBadClassification is returned for a config whose classification is not
+// valid.  Unlike other config errors, it stops the dispatcher from starting,
+// rather than the service being skipped.
+var errBadClassification = errors.New("invalid classification")
+
+// newDispatchService reads the given config file, and finds (or registers)
+// the Piazza service it describes.  State stores are shared between services
+// that name the same StateDir, and are opened into the given map as needed.
+func newDispatchService(s pzsvc.Session, configPath string, stores map[string]*stateStore) (*dispatchService, error) {
+	// ReadFile returns the contents of the file as a byte buffer.
+	configBuf, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, pzsvc.LogSimpleErr(s, "Dispatcher error in reading config "+configPath+": ", err)
+	}
+	var configObj pzsvc.Config
+	err = json.Unmarshal(configBuf, &configObj)
+	if err != nil {
+		return nil, pzsvc.LogSimpleErr(s, "Dispatcher error in unmarshalling config "+configPath+": ", err)
+	}
+
+	if configObj.SvcName == "" {
+		return nil, pzsvc.LogSimpleErr(s, "Config: Cannot work tasks without service name.", nil)
+	}
+	s.SessionID = configObj.SvcName
+	s = s.WithField("service", configObj.SvcName)
+
+	s.LogAudit = configObj.LogAudit
+	if configObj.LogAudit {
+		pzsvc.LogInfo(s, "Config: Audit logging enabled.")
+	} else {
+		pzsvc.LogInfo(s, "Config: Audit logging disabled.")
+	}
+
+	s.PzAddr = configObj.PzAddr
+	if configObj.PzAddrEnVar != "" {
+		newAddr := os.Getenv(configObj.PzAddrEnVar)
+		if newAddr != "" {
+			s.PzAddr = newAddr
+		}
+	}
+	if s.PzAddr == "" {
+		return nil, pzsvc.LogSimpleErr(s, "Config: Cannot work tasks.  Must have either a valid PzAddr, or a valid and populated PzAddrEnVar.", nil)
+	}
+
+	if configObj.APIKeyEnVar == "" {
+		return nil, pzsvc.LogSimpleErr(s, "Config: Cannot work tasks without valid APIKeyEnVar.", nil)
+	}
+	apiKey := os.Getenv(configObj.APIKeyEnVar)
+	if apiKey == "" {
+		return nil, pzsvc.LogSimpleErr(s, "No API key at APIKeyEnVar.  Cannot work.", nil)
+	}
+	pzsvc.RegisterSecret(apiKey)
+	s.PzAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(apiKey+":"))
+	if err = pzsvc.SetupRedaction(configObj); err != nil {
+		return nil, pzsvc.LogSimpleErr(s, "Config: invalid RedactPatterns: ", err)
+	}
+	if s.Class, err = pzsvc.ServiceClassification(configObj); err != nil {
+		pzsvc.LogSimpleErr(s, "Config: invalid Classification: ", err)
+		return nil, errBadClassification
+	}
+
+	var store *stateStore
+	if configObj.StateDir != "" {
+		store = stores[configObj.StateDir]
+		if store == nil {
+			store, err = openStateStore(s, configObj.StateDir)
+			if err != nil {
+				return nil, pzsvc.LogSimpleErr(s, "Dispatcher could not open state store: ", err)
+			}
+			stores[configObj.StateDir] = store
+		}
+		pzsvc.LogInfo(s, "Config: Dispatcher state journal kept in "+configObj.StateDir)
+	}
+
+	// Check for the Service ID. If it exists, then grab the ID. If it doesn't exist, then Register it.
+	svcID, err := pzsvc.FindMySvc(s, configObj.SvcName)
+	if err != nil {
+		svcID = store.ServiceID(configObj.SvcName)
+		if svcID == "" {
+			return nil, pzsvc.LogSimpleErr(s, "Dispatcher could not find Piazza Service ID.  Initial Error: ", err)
+		}
+		pzsvc.LogInfo(s, "Could not look up service.  Using Service ID recorded by a previous run.")
+	}
+	if svcID == "" {
+		// If no Service ID is found, attempt to register it.
+		pzsvc.LogInfo(s, "Could not find service.  Will attempt to register it.")
+		if _, _, err = pzsvc.ParseConfigAndRegister(s, &configObj); err != nil {
+			return nil, errBadClassification
+		}
+
+		// With registration completed, Check back for Service ID
+		time.Sleep(time.Duration(1) * time.Second)
+		svcID, err = pzsvc.FindMySvc(s, configObj.SvcName)
+		if err != nil {
+			return nil, pzsvc.LogSimpleErr(s, "Dispatcher could not find new Service ID post registration.  Initial Error: ", err)
+		}
+		if svcID == "" {
+			return nil, pzsvc.LogSimpleErr(s, "Could not find service ID post registration. Please verify Service Registration and restart the application.", nil)
+		}
+	}
+
+	pzsvc.LogInfo(s, "Found target service.  ServiceID: "+svcID+".")
+	store.SetServiceID(configObj.SvcName, svcID)
+
+	return &dispatchService{
+		s:          s,
+		config:     configObj,
+		configPath: configPath,
+		svcID:      svcID,
+		store:      store,
+		tracker:    newJobTracker(svcID, store),
+	}, nil
+}
+
+// hasCapacity returns true if this service is below its own task limit,
+// given the number of its tasks currently running
+func (svc *dispatchService) hasCapacity(running int) bool {
+	return svc.config.TaskLimit <= 0 || running < svc.config.TaskLimit
+}
+
+// prefetchLimit returns the number of jobs this service may hold pulled from
+// Piazza but not yet launched
+func (svc *dispatchService) prefetchLimit() int {
+	if svc.config.PrefetchLimit <= 0 {
+		return 1
+	}
+	return svc.config.PrefetchLimit
+}
@@ -0,0 +1,321 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+const stateJournalName = "dispatcher-journal.jsonl"
+
+// compactAfterRecords is how many records may be appended to the journal
+// beyond those needed for the current state before it is compacted again
+const compactAfterRecords = 1000
+
+// the various kinds of entries that may appear in the state journal
+const (
+	stateRecService  = "service"
+	stateRecGrabbed  = "grabbed"
+	stateRecLaunched = "launched"
+	stateRecDone     = "done"
+)
+
+// stateRecord is a single line of the state journal
+type stateRecord struct {
+	Type     string                `json:"type"`
+	Time     time.Time             `json:"time"`
+	SvcName  string                `json:"svcName,omitempty"`
+	SvcID    string                `json:"svcId,omitempty"`
+	JobID    string                `json:"jobId,omitempty"`
+	UserID   string                `json:"userId,omitempty"`
+	TaskGUID string                `json:"taskGuid,omitempty"`
+	Task     *cfclient.TaskRequest `json:"task,omitempty"`
+}
+
+// storedJob is the current known state of a job that the dispatcher has
+// grabbed from Piazza and not yet seen resolved
+type storedJob struct {
+	JobID     string
+	SvcID     string
+	UserID    string
+	TaskGUID  string // blank if the task was never confirmed as launched
+	Task      *cfclient.TaskRequest
+	GrabbedAt time.Time
+}
+
+// stateStore is an append-only journal of dispatcher state, kept on disk so
+// that a restarted dispatcher can pick up the work its predecessor left in
+// flight.  A nil *stateStore is valid, and records nothing.
+type stateStore struct {
+	mu       sync.Mutex
+	s        pzsvc.Session
+	path     string
+	file     *os.File
+	appended int // records appended since the last compaction
+	svcIDs   map[string]string
+	jobs     map[string]*storedJob
+}
+
+// openStateStore reads the journal in the given directory (if any), compacts
+// it down to the current state, and opens it for further appends.  Errors
+// writing to the journal later on are logged to the given session.
+func openStateStore(s pzsvc.Session, dir string) (*stateStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	ss := &stateStore{
+		s:      s,
+		path:   filepath.Join(dir, stateJournalName),
+		svcIDs: map[string]string{},
+		jobs:   map[string]*storedJob{},
+	}
+	if err := ss.replay(); err != nil {
+		return nil, err
+	}
+	if err := ss.compact(); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+func (ss *stateStore) replay() error {
+	f, err := os.Open(ss.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec stateRecord
+		// A torn final line is the expected result of a crash mid-write; skip it.
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			continue
+		}
+		ss.apply(rec)
+	}
+	return scanner.Err()
+}
+
+func (ss *stateStore) apply(rec stateRecord) {
+	switch rec.Type {
+	case stateRecService:
+		ss.svcIDs[rec.SvcName] = rec.SvcID
+	case stateRecGrabbed:
+		ss.jobs[rec.JobID] = &storedJob{JobID: rec.JobID, SvcID: rec.SvcID, UserID: rec.UserID, Task: rec.Task, GrabbedAt: rec.Time}
+	case stateRecLaunched:
+		if job, ok := ss.jobs[rec.JobID]; ok {
+			job.TaskGUID = rec.TaskGUID
+		} else {
+			ss.jobs[rec.JobID] = &storedJob{JobID: rec.JobID, SvcID: rec.SvcID, UserID: rec.UserID, TaskGUID: rec.TaskGUID, GrabbedAt: rec.Time}
+		}
+	case stateRecDone:
+		delete(ss.jobs, rec.JobID)
+	}
+}
+
+// compact rewrites the journal so that it contains only the current state,
+// and leaves it open for appending.  If the rewrite fails, the existing
+// journal is left open as it was.
+func (ss *stateStore) compact() error {
+	tmpPath := ss.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for name, id := range ss.svcIDs {
+		if err == nil {
+			err = enc.Encode(stateRecord{Type: stateRecService, Time: time.Now(), SvcName: name, SvcID: id})
+		}
+	}
+	for _, job := range ss.jobs {
+		if err == nil {
+			err = enc.Encode(stateRecord{Type: stateRecGrabbed, Time: job.GrabbedAt, SvcID: job.SvcID, JobID: job.JobID, UserID: job.UserID, Task: job.Task})
+		}
+		if err == nil && job.TaskGUID != "" {
+			err = enc.Encode(stateRecord{Type: stateRecLaunched, Time: job.GrabbedAt, SvcID: job.SvcID, JobID: job.JobID, UserID: job.UserID, TaskGUID: job.TaskGUID})
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, ss.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	file, err := os.OpenFile(ss.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if ss.file != nil {
+		ss.file.Close()
+	}
+	ss.file = file
+	ss.appended = 0
+	return nil
+}
+
+// record applies the given record to the in-memory state and appends it to
+// the journal, syncing so that it survives a crash.  Failures are logged and
+// counted as well as returned, since most callers cannot act on them.  Once
+// enough records have piled up, the journal is compacted.
+func (ss *stateStore) record(rec stateRecord) error {
+	if ss == nil {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	rec.Time = time.Now()
+	ss.apply(rec)
+	err := ss.append(rec)
+	if err != nil {
+		stateJournalErrorsTotal.Inc()
+		return pzsvc.LogSimpleErr(ss.s, "Dispatcher could not write "+rec.Type+" record to state journal: ", err)
+	}
+
+	ss.appended++
+	if ss.appended > compactAfterRecords+len(ss.svcIDs)+2*len(ss.jobs) {
+		if err = ss.compact(); err != nil {
+			stateJournalErrorsTotal.Inc()
+			pzsvc.LogSimpleErr(ss.s, "Dispatcher could not compact state journal: ", err)
+		}
+	}
+	return nil
+}
+
+// append writes one record to the end of the journal and syncs it
+func (ss *stateStore) append(rec stateRecord) error {
+	byts, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = ss.file.Write(append(byts, '\n')); err != nil {
+		return err
+	}
+	return ss.file.Sync()
+}
+
+// SetServiceID records the Piazza service ID found for the given service name
+func (ss *stateStore) SetServiceID(svcName, svcID string) error {
+	return ss.record(stateRecord{Type: stateRecService, SvcName: svcName, SvcID: svcID})
+}
+
+// ServiceID returns the last recorded service ID for the given service name
+func (ss *stateStore) ServiceID(svcName string) string {
+	if ss == nil {
+		return ""
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.svcIDs[svcName]
+}
+
+// JobGrabbed records that a job has been taken from Piazza, along with the
+// task that is about to be launched for it
+func (ss *stateStore) JobGrabbed(svcID, jobID, userID string, task cfclient.TaskRequest) error {
+	return ss.record(stateRecord{Type: stateRecGrabbed, SvcID: svcID, JobID: jobID, UserID: userID, Task: &task})
+}
+
+// JobLaunched records that the CF task for the given job has been created
+func (ss *stateStore) JobLaunched(svcID, jobID, userID, taskGUID string) error {
+	return ss.record(stateRecord{Type: stateRecLaunched, SvcID: svcID, JobID: jobID, UserID: userID, TaskGUID: taskGUID})
+}
+
+// JobDone records that the given job no longer needs the dispatcher's attention
+func (ss *stateStore) JobDone(jobID string) error {
+	return ss.record(stateRecord{Type: stateRecDone, JobID: jobID})
+}
+
+// PendingJobs returns every job that was grabbed but not yet seen resolved
+func (ss *stateStore) PendingJobs() []storedJob {
+	if ss == nil {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	jobs := make([]storedJob, 0, len(ss.jobs))
+	for _, job := range ss.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// recoverJobs picks back up the jobs a previous dispatcher left in flight for
+// the given service.  Jobs with a known task go back under tracking.  Jobs
+// without one are matched against CF tasks by name, and relaunched if no
+// task was ever created for them.
+func recoverJobs(s pzsvc.Session, svcID string, cfClient *cfclient.Client, store *stateStore, tracker *jobTracker) {
+	s.SessionID = "Recovery"
+	for _, job := range store.PendingJobs() {
+		if job.SvcID != svcID {
+			continue
+		}
+		if job.TaskGUID != "" {
+			pzsvc.LogInfo(s, "Resuming tracking of Job "+job.JobID+" on CF task "+job.TaskGUID)
+			tracker.Track(job.JobID, job.UserID, job.TaskGUID)
+			continue
+		}
+
+		query := url.Values{}
+		query.Add("names", job.JobID)
+		tasks, err := cfClient.ListTasksByQuery(query)
+		if err != nil {
+			pzsvc.LogSimpleErr(s, "Could not look up CF tasks for recovered Job "+job.JobID+": ", err)
+			continue
+		}
+		if len(tasks) > 0 {
+			pzsvc.LogInfo(s, "Found CF task "+tasks[0].GUID+" for recovered Job "+job.JobID)
+			tracker.Track(job.JobID, job.UserID, tasks[0].GUID)
+			continue
+		}
+
+		if job.Task == nil {
+			pzsvc.SendExecResultError(s, s.PzAddr, svcID, job.JobID, pzsvc.PiazzaStatusFail, "Dispatcher restarted before the job could be launched")
+			store.JobDone(job.JobID)
+			continue
+		}
+		pzsvc.LogAudit(s, s.AppName, "Relaunching CF Task for recovered Job "+job.JobID, s.AppName, job.Task.Command, pzsvc.INFO)
+		task, err := cfClient.CreateTask(*job.Task)
+		if err != nil {
+			pzsvc.LogSimpleErr(s, "Could not relaunch recovered Job "+job.JobID+": ", err)
+			pzsvc.SendExecResultError(s, s.PzAddr, svcID, job.JobID, pzsvc.PiazzaStatusFail, "Could not relaunch CF task after dispatcher restart: "+err.Error())
+			store.JobDone(job.JobID)
+			continue
+		}
+		tracker.Track(job.JobID, job.UserID, task.GUID)
+	}
+}
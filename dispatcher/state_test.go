@@ -0,0 +1,123 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+// countJournalLines returns the number of lines in the journal in dir
+func countJournalLines(t *testing.T, dir string) int {
+	f, err := os.Open(filepath.Join(dir, stateJournalName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestStateStoreReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := pzsvc.Session{AppName: "test"}
+
+	store, err := openStateStore(s, dir)
+	if err != nil {
+		t.Fatal(`TestStateStoreReplay: could not open store: ` + err.Error())
+	}
+	store.SetServiceID("svc", "svc-id")
+	store.JobGrabbed("svc-id", "job-1", "user-1", cfclient.TaskRequest{Name: "job-1"})
+	store.JobGrabbed("svc-id", "job-2", "user-2", cfclient.TaskRequest{Name: "job-2"})
+	store.JobLaunched("svc-id", "job-1", "user-1", "task-1")
+	store.JobGrabbed("svc-id", "job-3", "user-3", cfclient.TaskRequest{Name: "job-3"})
+	store.JobDone("job-3")
+	store.file.Close()
+
+	// Simulate a crash in the middle of a write
+	f, err := os.OpenFile(filepath.Join(dir, stateJournalName), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"type":"done","jobId":"jo`)
+	f.Close()
+
+	store, err = openStateStore(s, dir)
+	if err != nil {
+		t.Fatal(`TestStateStoreReplay: could not reopen store: ` + err.Error())
+	}
+	defer store.file.Close()
+	if store.ServiceID("svc") != "svc-id" {
+		t.Error(`TestStateStoreReplay: service ID not recovered.`)
+	}
+	jobs := map[string]storedJob{}
+	for _, job := range store.PendingJobs() {
+		jobs[job.JobID] = job
+	}
+	if len(jobs) != 2 {
+		t.Errorf(`TestStateStoreReplay: expected 2 pending jobs, found %v`, jobs)
+	}
+	if jobs["job-1"].TaskGUID != "task-1" || jobs["job-1"].UserID != "user-1" {
+		t.Errorf(`TestStateStoreReplay: launched job not recovered: %+v`, jobs["job-1"])
+	}
+	if jobs["job-2"].TaskGUID != "" || jobs["job-2"].Task == nil || jobs["job-2"].Task.Name != "job-2" {
+		t.Errorf(`TestStateStoreReplay: unlaunched job not recovered: %+v`, jobs["job-2"])
+	}
+	if lines := countJournalLines(t, dir); lines != 4 {
+		t.Errorf(`TestStateStoreReplay: journal not compacted on open; %d lines.`, lines)
+	}
+}
+
+func TestStateStoreCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := openStateStore(pzsvc.Session{AppName: "test"}, dir)
+	if err != nil {
+		t.Fatal(`TestStateStoreCompaction: could not open store: ` + err.Error())
+	}
+	defer store.file.Close()
+	store.JobGrabbed("svc-id", "kept", "user", cfclient.TaskRequest{Name: "kept"})
+	for i := 0; i < compactAfterRecords; i++ {
+		store.JobGrabbed("svc-id", "churn", "user", cfclient.TaskRequest{Name: "churn"})
+		store.JobDone("churn")
+	}
+	if lines := countJournalLines(t, dir); lines > compactAfterRecords+3 {
+		t.Errorf(`TestStateStoreCompaction: journal grew to %d lines.`, lines)
+	}
+	if err = store.JobLaunched("svc-id", "kept", "user", "task"); err != nil {
+		t.Error(`TestStateStoreCompaction: could not append after compaction: ` + err.Error())
+	}
+	if jobs := store.PendingJobs(); len(jobs) != 1 || jobs[0].TaskGUID != "task" {
+		t.Errorf(`TestStateStoreCompaction: wrong pending jobs: %+v`, jobs)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+const (
+	reconcileInterval = 30 * time.Second
+	reportGracePeriod = 60 * time.Second
+)
+
+// trackedJob records the CF task that was launched to work a given Piazza job
+type trackedJob struct {
+	JobID      string
+	UserID     string
+	TaskGUID   string
+	LaunchedAt time.Time
+	FinishedAt time.Time // when the task was first seen in a final state; zero until then
+}
+
+// jobTracker keeps track of the jobs the dispatcher has launched tasks for, so
+// that jobs whose tasks die without reporting back to Piazza can be failed.
+// Changes are mirrored to the state store, if there is one.
+type jobTracker struct {
+	mu    sync.Mutex
+	svcID string
+	store *stateStore
+	jobs  map[string]*trackedJob
+}
+
+func newJobTracker(svcID string, store *stateStore) *jobTracker {
+	return &jobTracker{svcID: svcID, store: store, jobs: map[string]*trackedJob{}}
+}
+
+// Track starts tracking the given job/task pair
+func (jt *jobTracker) Track(jobID, userID, taskGUID string) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	jt.jobs[jobID] = &trackedJob{JobID: jobID, UserID: userID, TaskGUID: taskGUID, LaunchedAt: time.Now()}
+	jt.store.JobLaunched(jt.svcID, jobID, userID, taskGUID)
+}
+
+// Forget stops tracking the given job
+func (jt *jobTracker) Forget(jobID string) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	delete(jt.jobs, jobID)
+	jt.store.JobDone(jobID)
+}
+
+// Has returns true if the given job is being tracked
+func (jt *jobTracker) Has(jobID string) bool {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	_, ok := jt.jobs[jobID]
+	return ok
+}
+
+// Get returns the tracked job with the given ID, if there is one
+func (jt *jobTracker) Get(jobID string) (trackedJob, bool) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	job, ok := jt.jobs[jobID]
+	if !ok {
+		return trackedJob{}, false
+	}
+	return *job, true
+}
+
+// MarkFinished records that the task for the given job has stopped, and
+// returns the time at which that was first noticed
+func (jt *jobTracker) MarkFinished(jobID string) time.Time {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	job, ok := jt.jobs[jobID]
+	if !ok {
+		return time.Now()
+	}
+	if job.FinishedAt.IsZero() {
+		job.FinishedAt = time.Now()
+	}
+	return job.FinishedAt
+}
+
+// RunningByUser returns the number of tracked jobs belonging to each user
+func (jt *jobTracker) RunningByUser() map[string]int {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	counts := map[string]int{}
+	for _, job := range jt.jobs {
+		counts[job.UserID]++
+	}
+	return counts
+}
+
+// Jobs returns a snapshot of all currently tracked jobs
+func (jt *jobTracker) Jobs() []trackedJob {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	jobs := make([]trackedJob, 0, len(jt.jobs))
+	for _, job := range jt.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// reconcileJobs periodically compares the state of the CF tasks that have been
+// launched against the state of their Piazza jobs.  Tasks that have stopped
+// without their job having been resolved are reported to Piazza as failures,
+// and tasks still running for jobs cancelled in Piazza are terminated.
+func reconcileJobs(s pzsvc.Session, svcID string, cfClient *cfclient.Client, tracker *jobTracker) {
+	s.SessionID = "Reconcile"
+	for {
+		time.Sleep(reconcileInterval)
+		for _, job := range tracker.Jobs() {
+			reconcileJob(s, svcID, cfClient, tracker, job)
+		}
+	}
+}
+
+func reconcileJob(s pzsvc.Session, svcID string, cfClient *cfclient.Client, tracker *jobTracker, job trackedJob) {
+	task, err := cfClient.GetTaskByGuid(job.TaskGUID)
+	if err != nil {
+		pzsvc.LogSimpleErr(s, "Could not get CF task "+job.TaskGUID+" for Job "+job.JobID+": ", err)
+		return
+	}
+	if task.State != "RUNNING" && task.State != "SUCCEEDED" && task.State != "FAILED" {
+		return
+	}
+
+	status, pErr := pzsvc.GetJobStatus(s, job.JobID)
+	if pErr != nil {
+		pErr.Log(s, "Could not get Piazza status for Job "+job.JobID)
+		return
+	}
+	if task.State == "RUNNING" {
+		// A job cancelled in Piazza leaves its task running unless stopped
+		if pzsvc.PiazzaStatus(status.Status) == pzsvc.PiazzaStatusCancelled {
+			pzsvc.LogAudit(s, s.AppName, "Terminating CF task for cancelled Job "+job.JobID, job.TaskGUID, "", pzsvc.INFO)
+			if err := cfClient.TerminateTask(job.TaskGUID); err != nil {
+				pzsvc.LogSimpleErr(s, "Could not terminate CF task "+job.TaskGUID+" for cancelled Job "+job.JobID+": ", err)
+				return
+			}
+			tracker.Forget(job.JobID)
+		}
+		return
+	}
+	if pzsvc.PiazzaStatus(status.Status).IsFinal() {
+		tracker.Forget(job.JobID)
+		return
+	}
+
+	// The worker reports to Piazza just before its task exits, so allow a
+	// little time for that report to land before calling the job orphaned.
+	if time.Since(tracker.MarkFinished(job.JobID)) < reportGracePeriod {
+		return
+	}
+
+	reason := "CF task exited without reporting a result"
+	if task.State == "FAILED" {
+		reason = "CF task failed: " + task.Result.FailureReason
+	}
+	pzsvc.LogAudit(s, s.AppName, "Orphaned job detected for Job "+job.JobID, job.TaskGUID, reason, pzsvc.WARN)
+	pErr = pzsvc.SendExecResultError(s, s.PzAddr, svcID, job.JobID, pzsvc.PiazzaStatusFail, reason)
+	if pErr != nil {
+		pErr.Log(s, "Could not report orphaned Job "+job.JobID)
+		return
+	}
+	tracker.Forget(job.JobID)
+}
@@ -0,0 +1,508 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	auditQueueSize    = 1000
+	auditNetTimeout   = 5 * time.Second
+	auditFlushTimeout = 10 * time.Second
+	defaultAuditFiles = 5
+)
+
+// auditRetryInterval is how often a syslog sink tries again to reach its
+// collector, and to replay its spool
+var auditRetryInterval = 10 * time.Second
+
+// AuditSinkConfig describes one destination for audit log entries
+type AuditSinkConfig struct {
+	Type        string // "syslog" for a remote syslog collector, "file" for a local file, or "stdout"
+	Network     string // For syslog: "tcp" (the default), "tls", or "udp", which may lose entries without notice
+	Address     string // For syslog: the host:port of the collector
+	TLSInsecure bool   // For syslog over tls: true to skip verification of the collector's certificate
+	SpoolDir    string // For syslog: directory in which entries are kept while the collector cannot be reached.  Defaults to the system temp directory, which does not outlive a container.
+	Path        string // For file: the path of the audit log
+	MaxBytes    int64  // For file: the size at which the log is rotated.  Never rotated if zero.
+	MaxFiles    int    // For file: the number of rotated logs to keep.  Defaults to 5.
+}
+
+// auditSink is somewhere that audit entries are sent
+type auditSink interface {
+	write(entry logEntry)
+	flush(timeout time.Duration)
+}
+
+var (
+	auditSinksMu sync.Mutex
+	auditSinks   []auditSink
+)
+
+// SetupAuditSinks sends audit entries to the sinks given in the AuditSinks
+// setting of the config, rather than to AuditLogFunc.  If there are none,
+// audit entries continue to go to AuditLogFunc.
+func SetupAuditSinks(s Session, config Config) LoggedError {
+	sinks := []auditSink{}
+	for _, sinkConfig := range config.AuditSinks {
+		var sink auditSink
+		var err error
+		switch sinkConfig.Type {
+		case "stdout":
+			sink = stdoutAuditSink{}
+		case "file":
+			sink, err = newFileAuditSink(sinkConfig)
+		case "syslog":
+			sink, err = newSyslogAuditSink(sinkConfig)
+		default:
+			err = fmt.Errorf("unknown audit sink type %q", sinkConfig.Type)
+		}
+		if err != nil {
+			return LogSimpleErr(s, "Config: could not set up audit sink: ", err)
+		}
+		sinks = append(sinks, sink)
+		if ss, ok := sink.(*syslogAuditSink); ok && ss.network == "udp" {
+			LogWarn(s, "Config: Audit entries sent over udp are lossy: any the collector does not receive are lost without notice.  Use tcp or tls if every entry must arrive.")
+		}
+		LogInfo(s, "Config: Sending audit log to "+sinkConfig.Type+" "+sinkConfig.Address+sinkConfig.Path)
+	}
+
+	auditSinksMu.Lock()
+	auditSinks = sinks
+	auditSinksMu.Unlock()
+	return nil
+}
+
+// FlushAudit waits a short time for buffered audit entries to be delivered.
+// Any that cannot be delivered by then remain spooled on disk, for delivery
+// by the next process to use the same spool, if the spool is on storage that
+// outlives this process.  Call it before exiting.
+func FlushAudit() {
+	auditSinksMu.Lock()
+	sinks := auditSinks
+	auditSinksMu.Unlock()
+	for _, sink := range sinks {
+		sink.flush(auditFlushTimeout)
+	}
+}
+
+// writeAudit sends the entry to every configured sink.  Returns false if there
+// are none.
+func writeAudit(entry logEntry) bool {
+	auditSinksMu.Lock()
+	sinks := auditSinks
+	auditSinksMu.Unlock()
+	if len(sinks) == 0 {
+		return false
+	}
+	for _, sink := range sinks {
+		sink.write(entry)
+	}
+	return true
+}
+
+// auditSinkFailure reports a problem with an audit sink to the general log.
+// It cannot go through the audit log itself.
+func auditSinkFailure(msg string, err error) {
+	LogFunc(buildLogEntry(Session{AppName: "pzsvc-audit"}, ERROR, msg+err.Error(), nil).format())
+}
+
+// stdoutAuditSink sends audit entries to AuditLogFunc
+type stdoutAuditSink struct{}
+
+func (stdoutAuditSink) write(entry logEntry) {
+	AuditLogFunc(entry.format())
+}
+
+func (stdoutAuditSink) flush(timeout time.Duration) {}
+
+// fileAuditSink appends audit entries to a local file, rotating it once it
+// reaches a given size.  Each entry is synced to disk as it is written.
+type fileAuditSink struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newFileAuditSink(config AuditSinkConfig) (*fileAuditSink, error) {
+	if config.Path == "" {
+		return nil, errors.New("file audit sink requires a Path")
+	}
+	fs := &fileAuditSink{path: config.Path, maxBytes: config.MaxBytes, maxFiles: config.MaxFiles}
+	if fs.maxFiles <= 0 {
+		fs.maxFiles = defaultAuditFiles
+	}
+	return fs, fs.open()
+}
+
+func (fs *fileAuditSink) open() error {
+	file, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	fs.file = file
+	fs.size = info.Size()
+	return nil
+}
+
+// rotate moves the current log to path.1, path.1 to path.2 and so on, and
+// starts a fresh log
+func (fs *fileAuditSink) rotate() error {
+	fs.file.Close()
+	fs.file = nil
+	for i := fs.maxFiles - 1; i > 0; i-- {
+		os.Rename(fs.path+"."+strconv.Itoa(i), fs.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(fs.path, fs.path+".1"); err != nil {
+		return err
+	}
+	return fs.open()
+}
+
+func (fs *fileAuditSink) write(entry logEntry) {
+	line := entry.format() + "\n"
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var err error
+	if fs.file == nil {
+		err = fs.open()
+	} else if fs.maxBytes > 0 && fs.size > 0 && fs.size+int64(len(line)) > fs.maxBytes {
+		err = fs.rotate()
+	}
+	if err == nil {
+		_, err = fs.file.WriteString(line)
+		fs.size += int64(len(line))
+	}
+	if err == nil {
+		err = fs.file.Sync()
+	}
+	if err != nil {
+		auditSinkFailure("Could not write to audit log "+fs.path+": ", err)
+	}
+}
+
+func (fs *fileAuditSink) flush(timeout time.Duration) {}
+
+// syslogAuditSink sends audit entries to a remote syslog collector, in RFC
+// 5424 format.  Callers only queue entries, and never wait on the network or
+// the disk: a single routine owns the connection and the spool, and sends the
+// entries in the background.  While the collector cannot be reached, entries
+// are appended to a spool file instead, and the spool is replayed, in order,
+// once the collector is back.  If entries arrive faster than even the spool
+// can take them, the queue fills, and further entries are written by the
+// callers to an overflow file, which the sending routine moves onto the end
+// of the spool once it has emptied the queue.
+type syslogAuditSink struct {
+	network      string
+	address      string
+	tlsConfig    *tls.Config
+	spoolPath    string
+	overflowPath string
+	queue        chan string
+	pending      int64 // entries queued but not yet sent or spooled
+	dropped      int64 // entries lost because not even the overflow file could take them
+
+	// Held while queuing an entry, and while touching the overflow file, so
+	// that entries stay in order as they move between the two
+	overflowMu sync.Mutex
+	overflowed bool // true while entries are waiting in the overflow file
+
+	// Only touched from the sending routine
+	conn    net.Conn
+	down    bool // true once a send has failed, until the next retry
+	spooled bool // true while entries are waiting in the spool
+}
+
+var spoolNameRegexp = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+func newSyslogAuditSink(config AuditSinkConfig) (*syslogAuditSink, error) {
+	if config.Address == "" {
+		return nil, errors.New("syslog audit sink requires an Address")
+	}
+	network := config.Network
+	if network == "" {
+		network = "tcp"
+	}
+	if network != "udp" && network != "tcp" && network != "tls" {
+		return nil, fmt.Errorf("unknown syslog network %q", network)
+	}
+	spoolDir := config.SpoolDir
+	if spoolDir == "" {
+		spoolDir = os.TempDir()
+	}
+	if err := os.MkdirAll(spoolDir, 0700); err != nil {
+		return nil, err
+	}
+
+	spoolPath := filepath.Join(spoolDir, "audit-spool-"+spoolNameRegexp.ReplaceAllString(network+"-"+config.Address, "_")+".log")
+	ss := &syslogAuditSink{
+		network:      network,
+		address:      config.Address,
+		spoolPath:    spoolPath,
+		overflowPath: spoolPath + ".overflow",
+		queue:        make(chan string, auditQueueSize),
+	}
+	if network == "tls" {
+		host, _, _ := net.SplitHostPort(config.Address)
+		ss.tlsConfig = &tls.Config{ServerName: host, InsecureSkipVerify: config.TLSInsecure}
+	}
+	go ss.run()
+	return ss, nil
+}
+
+// write queues an entry for the sending routine.  Once the queue is full,
+// entries go to the overflow file instead, and keep doing so until the
+// sending routine has caught up, so that none overtakes another.
+func (ss *syslogAuditSink) write(entry logEntry) {
+	msg := entry.formatSyslog()
+	ss.overflowMu.Lock()
+	defer ss.overflowMu.Unlock()
+	if !ss.overflowed {
+		atomic.AddInt64(&ss.pending, 1)
+		select {
+		case ss.queue <- msg:
+			return
+		default:
+			atomic.AddInt64(&ss.pending, -1)
+		}
+	}
+	if err := appendSpool(ss.overflowPath, msg); err != nil {
+		if dropped := atomic.AddInt64(&ss.dropped, 1); dropped%auditQueueSize == 1 {
+			auditSinkFailure("Audit queue full, and could not spool overflow: ", fmt.Errorf("%d audit entries dropped so far: %s", dropped, err.Error()))
+		}
+		return
+	}
+	ss.overflowed = true
+}
+
+func (ss *syslogAuditSink) flush(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&ss.pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (ss *syslogAuditSink) run() {
+	ticker := time.NewTicker(auditRetryInterval)
+	if _, err := os.Stat(ss.spoolPath); err == nil {
+		// Left by a previous run
+		ss.spooled = true
+	}
+	if _, err := os.Stat(ss.overflowPath); err == nil {
+		ss.overflowMu.Lock()
+		ss.overflowed = true
+		ss.overflowMu.Unlock()
+	}
+	ss.mergeOverflow()
+	ss.drainSpool()
+	for {
+		select {
+		case msg := <-ss.queue:
+			ss.deliver(msg)
+			atomic.AddInt64(&ss.pending, -1)
+			ss.mergeOverflow()
+		case <-ticker.C:
+			ss.mergeOverflow()
+			ss.down = false
+			ss.drainSpool()
+		}
+	}
+}
+
+// mergeOverflow moves any entries in the overflow file onto the end of the
+// spool, once every entry queued ahead of them has been dealt with.  Callers
+// queue nothing while the overflow file is in use, so the queue empties.
+func (ss *syslogAuditSink) mergeOverflow() {
+	ss.overflowMu.Lock()
+	defer ss.overflowMu.Unlock()
+	if !ss.overflowed || len(ss.queue) > 0 {
+		return
+	}
+	overflow, err := ioutil.ReadFile(ss.overflowPath)
+	if err != nil && !os.IsNotExist(err) {
+		auditSinkFailure("Could not read audit overflow: ", err)
+		return
+	}
+	if len(overflow) > 0 {
+		file, err := os.OpenFile(ss.spoolPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err == nil {
+			_, err = file.Write(overflow)
+			if err == nil {
+				err = file.Sync()
+			}
+			file.Close()
+		}
+		if err != nil {
+			auditSinkFailure("Could not spool audit overflow: ", err)
+			return
+		}
+		ss.spooled = true
+	}
+	os.Remove(ss.overflowPath)
+	ss.overflowed = false
+}
+
+// deliver sends a single entry, unless the collector is down or older
+// entries are still waiting in the spool, in which case it is spooled.  Once
+// a send fails, no more are tried until the next retry, so that entries are
+// spooled as fast as they come while the collector is down.
+func (ss *syslogAuditSink) deliver(msg string) {
+	if !ss.down && !ss.spooled {
+		if err := ss.send(msg); err == nil {
+			return
+		}
+		ss.down = true
+	}
+	if err := ss.spool(msg); err != nil {
+		auditSinkFailure("Could not spool audit entry: ", err)
+		return
+	}
+	ss.spooled = true
+}
+
+// send writes a single entry to the collector, connecting first if need be.
+// Over TCP and TLS, entries are framed by octet counting, per RFC 6587.
+func (ss *syslogAuditSink) send(msg string) error {
+	if ss.conn == nil {
+		var conn net.Conn
+		var err error
+		dialer := &net.Dialer{Timeout: auditNetTimeout}
+		if ss.network == "tls" {
+			conn, err = tls.DialWithDialer(dialer, "tcp", ss.address, ss.tlsConfig)
+		} else {
+			conn, err = dialer.Dial(ss.network, ss.address)
+		}
+		if err != nil {
+			return err
+		}
+		ss.conn = conn
+	}
+
+	frame := msg
+	if ss.network != "udp" {
+		frame = strconv.Itoa(len(msg)) + " " + msg
+	}
+	ss.conn.SetWriteDeadline(time.Now().Add(auditNetTimeout))
+	if _, err := ss.conn.Write([]byte(frame)); err != nil {
+		ss.conn.Close()
+		ss.conn = nil
+		return err
+	}
+	return nil
+}
+
+// spool appends an entry to the spool file
+func (ss *syslogAuditSink) spool(msg string) error {
+	return appendSpool(ss.spoolPath, msg)
+}
+
+// appendSpool appends an entry to the given spool file.  Entries are quoted,
+// so that each takes a single line.
+func appendSpool(path, msg string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.WriteString(strconv.Quote(msg) + "\n"); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// drainSpool sends every spooled entry to the collector, in order, and
+// removes those sent from the spool
+func (ss *syslogAuditSink) drainSpool() {
+	if !ss.spooled {
+		return
+	}
+	file, err := os.Open(ss.spoolPath)
+	if os.IsNotExist(err) {
+		ss.spooled = false
+		return
+	}
+	if err != nil {
+		auditSinkFailure("Could not read audit spool: ", err)
+		return
+	}
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	file.Close()
+
+	sent := 0
+	for _, line := range lines {
+		msg, err := strconv.Unquote(line)
+		if err == nil {
+			if ss.send(msg) != nil {
+				ss.down = true
+				break
+			}
+		}
+		sent++
+	}
+	if sent == len(lines) {
+		os.Remove(ss.spoolPath)
+		ss.spooled = false
+		return
+	}
+	if sent > 0 {
+		tmpPath := ss.spoolPath + ".tmp"
+		remaining := ""
+		for _, line := range lines[sent:] {
+			remaining += line + "\n"
+		}
+		if err := writeFileSync(tmpPath, []byte(remaining)); err == nil {
+			os.Rename(tmpPath, ss.spoolPath)
+		}
+	}
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,187 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pzsvc-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	s := Session{AppName: "testApp", LogAudit: true}
+	if err := SetupAuditSinks(s, Config{AuditSinks: []AuditSinkConfig{{Type: "file", Path: path, MaxBytes: 400, MaxFiles: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { auditSinks = nil }()
+
+	for i := 0; i < 10; i++ {
+		LogAudit(s, "me", "act", "you", "entry", INFO)
+	}
+
+	current, _ := ioutil.ReadFile(path)
+	rotated, _ := ioutil.ReadFile(path + ".1")
+	if len(current) == 0 || len(current) > 400 || !strings.Contains(string(rotated), `[pzaudit@48851 actor="me" action="act" actee="you"] entry`) {
+		t.Errorf("TestFileAuditSink: bad rotation.  current: %s\nrotated: %s", current, rotated)
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("TestFileAuditSink: kept more rotated files than MaxFiles")
+	}
+}
+
+func TestSyslogAuditSinkSpool(t *testing.T) {
+	defer func(interval time.Duration) { auditRetryInterval = interval }(auditRetryInterval)
+	auditRetryInterval = 100 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "pzsvc-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Reserve an address, but leave nothing listening on it for now
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	sink, err := newSyslogAuditSink(AuditSinkConfig{Type: "syslog", Network: "tcp", Address: addr, SpoolDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.write(logEntry{Time: time.Now(), Severity: INFO, App: "testApp", Message: "first"})
+	sink.write(logEntry{Time: time.Now(), Severity: INFO, App: "testApp", Message: "second"})
+	sink.flush(time.Second)
+	if byts, _ := ioutil.ReadFile(sink.spoolPath); strings.Count(string(byts), "\n") != 2 {
+		t.Fatalf("TestSyslogAuditSinkSpool: expected 2 spooled entries, got: %s", byts)
+	}
+
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 3)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			lengthStr, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			length, err := strconv.Atoi(strings.TrimSpace(lengthStr))
+			if err != nil {
+				return
+			}
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return
+			}
+			received <- string(buf)
+		}
+	}()
+
+	sink.write(logEntry{Time: time.Now(), Severity: INFO, App: "testApp", Message: "third"})
+	for _, expected := range []string{"first", "second", "third"} {
+		select {
+		case msg := <-received:
+			if !strings.HasSuffix(msg, " "+expected) || !strings.HasPrefix(msg, "<14>1 ") {
+				t.Errorf("TestSyslogAuditSinkSpool: expected entry %q, got %q", expected, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestSyslogAuditSinkSpool: timed out waiting for entry %q", expected)
+		}
+	}
+	if _, err := os.Stat(sink.spoolPath); !os.IsNotExist(err) {
+		t.Error("TestSyslogAuditSinkSpool: spool not removed after replay")
+	}
+}
+
+func TestSyslogAuditSinkFullQueue(t *testing.T) {
+	// With no routine taking entries off the queue, writes beyond its size
+	// must go to the overflow file rather than wait, and then end up on the
+	// spool behind the queued entry
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spoolPath := filepath.Join(dir, "spool.log")
+	sink := &syslogAuditSink{network: "tcp", spoolPath: spoolPath, overflowPath: spoolPath + ".overflow", queue: make(chan string, 1)}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 3; i++ {
+			sink.write(logEntry{Time: time.Now(), Severity: INFO, App: "testApp", Message: "entry" + strconv.Itoa(i)})
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("TestSyslogAuditSinkFullQueue: write blocked on a full queue")
+	}
+	if sink.dropped != 0 || sink.pending != 1 || !sink.overflowed {
+		t.Errorf("TestSyslogAuditSinkFullQueue: expected 0 dropped, 1 pending and overflow in use, got %d, %d and %t", sink.dropped, sink.pending, sink.overflowed)
+	}
+
+	// Nothing is merged while an entry is still queued ahead of the overflow
+	sink.mergeOverflow()
+	if _, err := os.Stat(spoolPath); !os.IsNotExist(err) {
+		t.Error("TestSyslogAuditSinkFullQueue: overflow spooled ahead of a queued entry")
+	}
+	sink.down = true
+	sink.deliver(<-sink.queue)
+	sink.mergeOverflow()
+	if sink.overflowed {
+		t.Error("TestSyslogAuditSinkFullQueue: overflow still in use after merging")
+	}
+	if _, err := os.Stat(sink.overflowPath); !os.IsNotExist(err) {
+		t.Error("TestSyslogAuditSinkFullQueue: overflow file not removed after merging")
+	}
+	spooled, err := ioutil.ReadFile(spoolPath)
+	if err != nil {
+		t.Fatal("TestSyslogAuditSinkFullQueue: " + err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(string(spooled)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("TestSyslogAuditSinkFullQueue: expected 3 spooled entries, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, "entry"+strconv.Itoa(i)) {
+			t.Errorf("TestSyslogAuditSinkFullQueue: spooled entry %d out of order: %s", i, line)
+		}
+	}
+}
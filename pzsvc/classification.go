@@ -0,0 +1,84 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultClassLevels are the classifications allowed when a config does not
+// give ClassLevels, from lowest to highest.
+var DefaultClassLevels = []string{"UNCLASSIFIED", "CONFIDENTIAL", "SECRET", "TOP SECRET"}
+
+// classLevels returns the allowed classifications of the given config, from
+// lowest to highest.
+func classLevels(config Config) []string {
+	if len(config.ClassLevels) == 0 {
+		return DefaultClassLevels
+	}
+	return config.ClassLevels
+}
+
+// classRank returns the position of the given classification among the
+// allowed levels, or -1 if it is not one of them.  Case is ignored.
+func classRank(levels []string, class string) int {
+	class = strings.TrimSpace(class)
+	for i, level := range levels {
+		if strings.EqualFold(level, class) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ServiceClassification returns the classification of the service
+// described by the given config: its Classification, or the lowest allowed
+// level if that is blank.  It returns an error if the classification is not
+// an allowed level.
+func ServiceClassification(config Config) (string, error) {
+	levels := classLevels(config)
+	if config.Classification == "" {
+		return levels[0], nil
+	}
+	rank := classRank(levels, config.Classification)
+	if rank < 0 {
+		return "", fmt.Errorf(`classification "%s" is not one of the allowed levels (%s)`, config.Classification, strings.Join(levels, ", "))
+	}
+	return levels[rank], nil
+}
+
+// JobClassification returns the classification for a job on the service
+// described by the given config.  The job may ask for a classification of
+// its own, which must be an allowed level no lower than the service's.  If
+// it asks for none, the service's is used.
+func JobClassification(config Config, requested string) (string, error) {
+	svcClass, err := ServiceClassification(config)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(requested) == "" {
+		return svcClass, nil
+	}
+	levels := classLevels(config)
+	rank := classRank(levels, requested)
+	if rank < 0 {
+		return "", fmt.Errorf(`classification "%s" is not one of the allowed levels (%s)`, requested, strings.Join(levels, ", "))
+	}
+	if rank < classRank(levels, svcClass) {
+		return "", fmt.Errorf(`classification "%s" is lower than the service's classification of "%s"`, requested, svcClass)
+	}
+	return levels[rank], nil
+}
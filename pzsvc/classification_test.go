@@ -0,0 +1,65 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"testing"
+)
+
+func TestClassification(t *testing.T) {
+	if class, err := ServiceClassification(Config{}); err != nil || class != "UNCLASSIFIED" {
+		t.Errorf(`TestClassification: default service classification was "%s", %v`, class, err)
+	}
+	if _, err := ServiceClassification(Config{Classification: "RESTRICTED"}); err == nil {
+		t.Error("TestClassification: accepted a service classification that is not allowed")
+	}
+
+	config := Config{Classification: "confidential"}
+	cases := map[string]string{
+		"":             "CONFIDENTIAL",
+		"Confidential": "CONFIDENTIAL",
+		" secret ":     "SECRET",
+		"TOP SECRET":   "TOP SECRET",
+	}
+	for requested, expected := range cases {
+		if class, err := JobClassification(config, requested); err != nil || class != expected {
+			t.Errorf(`TestClassification: for "%s", expected "%s", got "%s", %v`, requested, expected, class, err)
+		}
+	}
+	for _, requested := range []string{"UNCLASSIFIED", "RESTRICTED"} {
+		if _, err := JobClassification(config, requested); err == nil {
+			t.Errorf(`TestClassification: accepted "%s"`, requested)
+		}
+	}
+
+	config = Config{Classification: "INTERNAL", ClassLevels: []string{"PUBLIC", "INTERNAL"}}
+	if _, err := JobClassification(config, "SECRET"); err == nil {
+		t.Error("TestClassification: accepted a level outside of ClassLevels")
+	}
+	if class, err := JobClassification(config, "internal"); err != nil || class != "INTERNAL" {
+		t.Errorf(`TestClassification: for "internal", got "%s", %v`, class, err)
+	}
+}
+
+func TestParseConfigClassification(t *testing.T) {
+	s := Session{AppName: "test"}
+	if _, _, err := ParseConfigAndRegister(s, &Config{CliCmd: "echo", Classification: "RESTRICTED"}); err == nil {
+		t.Error("TestParseConfigClassification: no error for a classification that is not allowed")
+	}
+	_, outSession, err := ParseConfigAndRegister(s, &Config{CliCmd: "echo", Classification: "secret"})
+	if err != nil || outSession.Class != "SECRET" {
+		t.Errorf(`TestParseConfigClassification: expected "SECRET", got "%s", %v`, outSession.Class, err)
+	}
+}
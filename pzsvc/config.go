@@ -21,32 +21,73 @@ import (
 
 // Config represents and contains the information from a pzsvc-exec config file.
 type Config struct {
-	CliCmd        string            // The first segment of the command to send to the CLI.  Security vulnerability when blank.
-	VersionStr    string            // The version number of the underlying CLI.  Redundant with VersionCmd
-	VersionCmd    string            // The command to run to determine the version number of the underlying CLI.  Redundant with VersionStr
-	PzAddr        string            // Address of local Piazza instance.  Used for Piazza file access.  Necessary for autoregistration, task worker.
-	PzAddrEnVar   string            // Environment variable holding Piazza address.  Used to populate/overwrite PzAddr if present
-	APIKeyEnVar   string            // The environment variable containing the api key for the local Piazza instance.  Used for the same things.
-	SvcName       string            // The name to give for this service when registering.  Necessary for autoregistration, task worker.
-	URL           string            // URL to give when registering.  Required when registering and not using task manager.
-	Port          int               // Port to publish this service on.  Defaults to 8080.
-	PortEnVar     string            // Environment variable to check for port.  Mutually exclusive with "Port"
-	Description   string            // Description to return when asked.
-	Attributes    map[string]string // Service attributes.  Used to improve searching/sorting of services.
-	NumProcs      int               // Number of jobs a single instance of this service can handle simultaneously
-	CanUpload     bool              // True if this service is permitted to upload files
-	CanDownlPz    bool              // True if this service is permitted to download files from Piazza
-	CanDownlExt   bool              // True if this service is permitted to download files from an external source
-	RegForTaskMgr bool              // True if autoregistration should be as a service using the Pz task manager
-	MaxRunTime    int               // Time in seconds before a running job should be considered to have failed.  Used for task worker registration.
-	LocalOnly     bool              // True if service should only accept connections from localhost (used with task worker)
-	LogAudit      bool              // True to log all auditable events
-	LimitUserData bool              // True to limit the information availabel to the individual user
-	ExtRetryOn202 bool              // If true, will retry when receiving a 202 response from external file download links
-	DocURL        string            // URL to provide to autoregistration and to documentation endpoint for info about the service
+	CliCmd         string            // The first segment of the command to send to the CLI.  Security vulnerability when blank.
+	VersionStr     string            // The version number of the underlying CLI.  Redundant with VersionCmd
+	VersionCmd     string            // The command to run to determine the version number of the underlying CLI.  Redundant with VersionStr
+	PzAddr         string            // Address of local Piazza instance.  Used for Piazza file access.  Necessary for autoregistration, task worker.
+	PzAddrEnVar    string            // Environment variable holding Piazza address.  Used to populate/overwrite PzAddr if present
+	APIKeyEnVar    string            // The environment variable containing the api key for the local Piazza instance.  Used for the same things.
+	SvcName        string            // The name to give for this service when registering.  Necessary for autoregistration, task worker.
+	URL            string            // URL to give when registering.  Required when registering and not using task manager.
+	Port           int               // Port to publish this service on.  Defaults to 8080.
+	PortEnVar      string            // Environment variable to check for port.  Mutually exclusive with "Port"
+	Description    string            // Description to return when asked.
+	Attributes     map[string]string // Service attributes.  Used to improve searching/sorting of services.
+	NumProcs       int               // Number of jobs a single instance of this service can handle simultaneously
+	CanUpload      bool              // True if this service is permitted to upload files
+	CanDownlPz     bool              // True if this service is permitted to download files from Piazza
+	CanDownlExt    bool              // True if this service is permitted to download files from an external source
+	RegForTaskMgr  bool              // True if autoregistration should be as a service using the Pz task manager
+	MaxRunTime     int               // Time in seconds before a running job should be considered to have failed.  Used for task worker registration.
+	LocalOnly      bool              // True if service should only accept connections from localhost (used with task worker)
+	LogAudit       bool              // True to log all auditable events
+	LimitUserData  bool              // True to limit the information availabel to the individual user
+	ExtRetryOn202  bool              // If true, will retry when receiving a 202 response from external file download links
+	DocURL         string            // URL to provide to autoregistration and to documentation endpoint for info about the service
+	StateDir       string            // Directory in which the dispatcher journals in-flight jobs, for recovery after a restart.  Not persisted if blank.
+	TaskLimit      int               // Maximum number of simultaneous tasks the dispatcher will run for this service.  Limited only by TASK_LIMIT if zero.
+	PrefetchLimit  int               // Number of jobs the dispatcher may pull from Piazza ahead of launching them, to choose fairly between users.  Defaults to 1.
+	MetricsPushURL string            // Address of a Prometheus pushgateway to which the worker sends its metrics at the end of each job.  Metrics are only logged if blank.
+	ProgressPeriod int               // Least number of seconds between the progress updates the worker relays to Piazza from "PROGRESS: n%" lines in the algorithm's output.  Defaults to 10.
+	CancelPeriod   int               // Seconds between the worker's checks of Piazza for cancellation of its job.  Defaults to 30.  Never checked if negative.
+	TraceExporter  string            // Where trace spans are sent: "otlp" for an OTLP/HTTP collector, or "file" to append them to a file.  Not traced if blank.
+	TraceEndpoint  string            // The collector address for "otlp" tracing (default http://localhost:4318), or the file path for "file" tracing.
+	LogFormat      string            // Format of log output: "syslog" (the default), "json" or "logfmt".
+	LogLevel       string            // Least severe level of log entry to output: "debug" (the default), "info", "notice", "warn" or "error".  Audit entries are not filtered.
+	AuditSinks     []AuditSinkConfig // Destinations for audit log entries, in place of the general log output.  See AuditSinkConfig.
+	RedactPatterns []string          // Regular expressions for further secrets to scrub from logs, beyond the known auth fields and headers.
+	RetryPolicy    *RetryPolicy      // When and how the worker retries a failed algorithm run.  Run only once if nil.
+	ExitCodes      map[string]string // Outcome of each algorithm exit code: "success", "warning", "inputError" or "internalError".  Unlisted codes succeed if zero and are internal errors otherwise.
+	IngestPartial  bool              // True to ingest whatever outputs exist when the algorithm fails, rather than none
+	MaxOutputFiles int               // Most output files the worker will ingest for one job, after expanding patterns and directories.  Unlimited if zero.
+	MaxOutputBytes int64             // Most bytes of output files the worker will ingest for one job.  Unlimited if zero.
+	IngestTimeout  int               // Seconds allowed for the ingest of each output file, plus one more for each MB of the file.  Defaults to 60.
+	OutputRules    []OutputRule      // Checks made of output files before any are ingested.  A job whose outputs fail them fails.  See OutputRule.
+	ProvSidecar    bool              // True to ingest a W3C PROV-JSON document relating each job's outputs to its inputs, alongside the outputs
+	Classification string            // Classification of the service and of the data it produces.  Jobs may raise it but not lower it.  Defaults to the lowest of ClassLevels.
+	ClassLevels    []string          // Allowed classifications, from lowest to highest.  Defaults to UNCLASSIFIED, CONFIDENTIAL, SECRET, TOP SECRET.
 	//JwtSecAuthURL string            // URL for taskworker to decrypt JWT.  If nonblank, will assume that all jobs are JWT format, and will require decrypting.
 }
 
+// RetryPolicy describes when the worker should run a failed algorithm again.
+// A failure is retryable if its exit code is in RetryExitCodes or its stderr
+// matches one of RetryStderrPatterns.  If neither is given, every failure is.
+type RetryPolicy struct {
+	MaxAttempts         int      // Total number of runs allowed, including the first.  Defaults to 1.
+	RetryExitCodes      []int    // Exit codes that mark a failure as retryable
+	RetryStderrPatterns []string // Regular expressions that mark a failure as retryable when found in its stderr
+	BackoffSeconds      int      // Seconds to wait before the first retry, doubling before each further retry
+	RedownloadInputs    bool     // True to download the job's inputs again before each retry
+}
+
+// OutputRule describes the checks made of the output files it applies to
+type OutputRule struct {
+	Pattern    string // Glob pattern matched against the base names of output files.  Applies to every output if blank.
+	Type       string // The format the files must be in: "geojson" (a FeatureCollection or Feature), "geotiff", or "json".  Not checked if blank.
+	JSONSchema string // For json and geojson: the path of a JSON Schema file that the files must satisfy
+	MaxBytes   int64  // Most bytes a file may hold.  Unlimited if zero.
+}
+
 // ConfigParseOut is a handy struct to organize all of the outputs
 // for pzse.ConfigParse() and prevent potential confusion.
 type ConfigParseOut struct {
@@ -56,8 +97,10 @@ type ConfigParseOut struct {
 
 // ParseConfigAndRegister parses the config file on starting up, manages
 // registration for it on the given Pz instance if registration management
-// is called for, and returns a few useful derived values
-func ParseConfigAndRegister(s Session, configObj *Config) (ConfigParseOut, Session) {
+// is called for, and returns a few useful derived values.  Returns an error,
+// without registering, if the config's classification is not valid, in
+// which case the application must not start.
+func ParseConfigAndRegister(s Session, configObj *Config) (ConfigParseOut, Session, error) {
 	canReg := checkConfig(s, configObj)
 	canPzFile := configObj.CanUpload || configObj.CanDownlPz
 
@@ -119,13 +162,21 @@ func ParseConfigAndRegister(s Session, configObj *Config) (ConfigParseOut, Sessi
 
 	version := getVersion(s, configObj)
 
+	// Data must never be marked lower than it should be, so a service with
+	// an invalid classification does not start at all
+	svcClass, err := ServiceClassification(*configObj)
+	if err != nil {
+		LogAlert(s, "Config: "+err.Error()+".  The application cannot start.")
+		return ConfigParseOut{}, s, err
+	}
+	s.Class = svcClass
+
 	if canReg {
 		LogInfo(s, "About to manage registration.")
 
-		svcClass := ClassType{Classification: "UNCLASSIFIED"} // TODO: this will have to be updated at some point.
 		metaObj := ResMeta{Name: configObj.SvcName,
 			Description: configObj.Description,
-			ClassType:   svcClass,
+			ClassType:   ClassType{Classification: svcClass},
 			Version:     version,
 			Metadata:    make(map[string]string)}
 		for key, val := range configObj.Attributes {
@@ -154,7 +205,7 @@ func ParseConfigAndRegister(s Session, configObj *Config) (ConfigParseOut, Sessi
 	s.LogRootDir = "pzsvc-exec"
 	s.LogAudit = configObj.LogAudit
 
-	return ConfigParseOut{portStr, version}, s
+	return ConfigParseOut{portStr, version}, s, nil
 }
 
 // checkConfig takes an input config file, checks it over for issues,
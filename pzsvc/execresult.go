@@ -20,13 +20,15 @@ import (
 )
 
 type statusUpdateJSON struct {
-	Status PiazzaStatus            `json:"status"`
-	Result *statusUpdateResultJSON `json:"result,omitempty"`
+	Status   PiazzaStatus            `json:"status"`
+	Progress *JobProg                `json:"progress,omitempty"`
+	Result   *statusUpdateResultJSON `json:"result,omitempty"`
 }
 
 type statusUpdateResultJSON struct {
-	Type   string `json:"type"`
-	DataID string `json:"dataId"`
+	Type    string `json:"type"`
+	DataID  string `json:"dataId,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
 // SendExecResultNoData sends the result of a job execution to Piazza
@@ -37,8 +39,30 @@ func SendExecResultNoData(s Session, pzAddr, svcID, jobID string, status PiazzaS
 	outData := statusUpdateJSON{Status: status}
 	outJSON, _ := json.Marshal(outData)
 
-	_, err := SubmitSinglePart("POST", string(outJSON), outAddr, s.PzAuth)
-	return err
+	return postTaskUpdate(s, outAddr, outJSON)
+}
+
+// SendExecResultError sends the result of a job execution to Piazza, including
+// an error message describing why the job did not complete
+func SendExecResultError(s Session, pzAddr, svcID, jobID string, status PiazzaStatus, message string) *PzCustomError {
+	outAddr := fmt.Sprintf("%s/service/%s/task/%s", pzAddr, svcID, jobID)
+
+	LogInfo(s, fmt.Sprintf("Sending exec results, with error message. URL=%s Status=%s Message=%s", outAddr, status, message))
+	outData := statusUpdateJSON{Status: status, Result: &statusUpdateResultJSON{Type: "error", Message: message}}
+	outJSON, _ := json.Marshal(outData)
+
+	return postTaskUpdate(s, outAddr, outJSON)
+}
+
+// SendExecProgress tells Piazza how far a running job has got
+func SendExecProgress(s Session, pzAddr, svcID, jobID string, progress JobProg) *PzCustomError {
+	outAddr := fmt.Sprintf("%s/service/%s/task/%s", pzAddr, svcID, jobID)
+
+	LogInfo(s, fmt.Sprintf("Sending exec progress. URL=%s PercentComplete=%d", outAddr, progress.PercentComplete))
+	outData := statusUpdateJSON{Status: PiazzaStatusRunning, Progress: &progress}
+	outJSON, _ := json.Marshal(outData)
+
+	return postTaskUpdate(s, outAddr, outJSON)
 }
 
 // SendExecResultData sends the result of a job execution to Piazza, including extra text data
@@ -59,6 +83,17 @@ func SendExecResultData(s Session, pzAddr, svcID, jobID string, status PiazzaSta
 	}
 
 	outJSON, _ := json.Marshal(outData)
-	_, httpErr := SubmitSinglePart("POST", string(outJSON), outAddr, s.PzAuth)
-	return httpErr
+	return postTaskUpdate(s, outAddr, outJSON)
+}
+
+// postTaskUpdate posts a status update for a task to Piazza.  Piazza has
+// not taken the update unless it answers with a 2xx status, and any other
+// status is returned as an error.  The response body is not needed, and is
+// closed.
+func postTaskUpdate(s Session, outAddr string, outJSON []byte) *PzCustomError {
+	resp, err := submitSinglePart(s.Span, "POST", string(outJSON), outAddr, s.PzAuth)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return err
 }
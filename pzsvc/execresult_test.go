@@ -0,0 +1,56 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSendExecUpdates(t *testing.T) {
+	defer SetHTTPClient(nil)
+	s := Session{AppName: "test", PzAuth: "testAuth"}
+	senders := map[string]func() *PzCustomError{
+		"result": func() *PzCustomError {
+			return SendExecResultNoData(s, "http://pz.test", "svc", "job", PiazzaStatusSuccess)
+		},
+		"error": func() *PzCustomError {
+			return SendExecResultError(s, "http://pz.test", "svc", "job", PiazzaStatusFail, "failed")
+		},
+		"progress": func() *PzCustomError {
+			return SendExecProgress(s, "http://pz.test", "svc", "job", JobProg{PercentComplete: 50})
+		},
+	}
+	for name, send := range senders {
+		body := &closeRecorder{Reader: strings.NewReader(`{}`)}
+		var reqURL string
+		SetHTTPClient(&http.Client{Transport: closeRecorderTransport{body: body, url: &reqURL}})
+		if err := send(); err != nil {
+			t.Errorf(`TestSendExecUpdates: %s: %s`, name, err.Error())
+		}
+		if !body.closed {
+			t.Errorf(`TestSendExecUpdates: %s: response body left open.`, name)
+		}
+		if reqURL != "http://pz.test/service/svc/task/job" {
+			t.Errorf(`TestSendExecUpdates: %s: sent to %s`, name, reqURL)
+		}
+
+		SetMockClient(nil, http.StatusServiceUnavailable)
+		if err := send(); err == nil {
+			t.Errorf(`TestSendExecUpdates: %s: no error when Piazza refused the update.`, name)
+		}
+	}
+}
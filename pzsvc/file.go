@@ -15,10 +15,18 @@
 package pzsvc
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 // locString simplifies certain local processes that wish to interact with
@@ -30,30 +38,26 @@ func locString(subFold, fname string) string {
 	return fmt.Sprintf(`./%s/%s`, subFold, fname)
 }
 
-// Ingest ingests the given bytes to Piazza.
+// Ingest ingests the given bytes to Piazza.  The bytes must match the given
+// type: a zip archive holding a shapefile for 'shapefile', a LAS or LAZ file
+// for 'pointcloud', and text for 'text'.
 func Ingest(s Session, fName, fType, sourceName, version string,
 	ingData []byte,
 	props map[string]string) (string, LoggedError) {
 
-	var (
-		fileData []byte
-		resp     *http.Response
-		pErr     *PzCustomError
-		targAddr string
-	)
+	data := io.NewSectionReader(bytes.NewReader(ingData), 0, int64(len(ingData)))
+	return ingestData(s, fName, fType, sourceName, version, data, props)
+}
 
-	desc := fmt.Sprintf("%s uploaded by %s.", fType, sourceName)
-	rMeta := ResMeta{
-		Name:        fName,
-		Format:      fType,
-		ClassType:   ClassType{"UNCLASSIFIED"},
-		Version:     version,
-		Description: desc,
-		Metadata:    make(map[string]string)}
+// ingestData ingests the given data to Piazza.  Data other than text is
+// streamed to Piazza, rather than being read into memory.  The bounding box,
+// feature count and coordinate reference system of GeoJSON and GeoTIFF data
+// are sent along as its spatial metadata.
+func ingestData(s Session, fName, fType, sourceName, version string,
+	data *io.SectionReader,
+	props map[string]string) (string, LoggedError) {
 
-	for key, val := range props {
-		rMeta.Metadata[key] = val
-	}
+	var fileData *io.SectionReader
 
 	dType := DataType{Type: fType}
 
@@ -61,23 +65,122 @@ func Ingest(s Session, fName, fType, sourceName, version string,
 	case "raster":
 		{
 			//dType.MimeType = "image/tiff"
-			fileData = ingData
+			fileData = data
 		}
 	case "geojson":
 		{
 			dType.MimeType = "application/vnd.geo+json"
-			fileData = ingData
+			fileData = data
+		}
+	case "shapefile":
+		{
+			if err := checkZippedShapefile(data); err != nil {
+				return "", LogSimpleErr(s, `Cannot ingest "`+fName+`" as a shapefile: `, err)
+			}
+			dType.MimeType = "application/zip"
+			fileData = data
+		}
+	case "pointcloud":
+		{
+			magic := make([]byte, len(lasMagic))
+			if _, err := data.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, lasMagic) {
+				return "", LogSimpleErr(s, `Cannot ingest "`+fName+`" as a point cloud: not a LAS or LAZ file.`, nil)
+			}
+			dType.MimeType = "application/vnd.las"
+			if strings.ToLower(filepath.Ext(fName)) == ".laz" {
+				dType.MimeType = "application/vnd.laszip"
+			}
+			fileData = data
 		}
 	case "text":
 		{
+			ingData, err := ioutil.ReadAll(data)
+			if err != nil {
+				return "", LogSimpleErr(s, `Error reading "`+fName+`" for Ingest: `, err)
+			}
+			if !IsText(ingData, false) {
+				return "", LogSimpleErr(s, `Refusing to ingest binary content of "`+fName+`" as text.`, nil)
+			}
 			dType.MimeType = "application/text"
 			dType.Content = string(ingData)
 			fileData = nil
 		}
+	default:
+		return "", LogSimpleErr(s, `Cannot ingest "`+fName+`": unsupported type "`+fType+`" for uploaded data.`, nil)
+	}
+
+	spatMeta, err := spatialMetadata(fType, data)
+	if err != nil {
+		// Missing spatial metadata should not keep the data out of Piazza
+		LogWarn(s, `Could not read spatial metadata of "`+fName+`": `+err.Error())
+	}
+
+	return submitIngest(s, fName, fType, sourceName, version, dType, spatMeta, true, fileData, props)
+}
+
+// IngestReference registers data that lives elsewhere with Piazza, without
+// uploading it.  The DataType describes where the data is: for 'wfs', its
+// URL, FeatureType and Version, or for other types, the URL of the data.
+// It is for services built on this package; the worker has no use for it, as
+// job outputs are always files to upload.
+func IngestReference(s Session, fName, sourceName, version string,
+	dType DataType,
+	props map[string]string) (string, LoggedError) {
+
+	if dType.Type == "" {
+		return "", LogSimpleErr(s, `Cannot register "`+fName+`": no data type given.`, nil)
+	}
+	if dType.Content != "" || dType.Location != nil {
+		return "", LogSimpleErr(s, `Cannot register "`+fName+`": data registered by reference must not carry content or a file location.`, nil)
+	}
+	if dType.Type == "wfs" && dType.FeatureType == "" {
+		return "", LogSimpleErr(s, `Cannot register "`+fName+`" as WFS data: no feature type given.`, nil)
+	}
+	refURL, err := url.Parse(dType.URL)
+	if err != nil || (refURL.Scheme != "http" && refURL.Scheme != "https") || refURL.Host == "" {
+		return "", LogSimpleErr(s, `Cannot register "`+fName+`": "`+dType.URL+`" is not an http or https URL.`, nil)
+	}
+
+	return submitIngest(s, fName, dType.Type, sourceName, version, dType, nil, false, nil, props)
+}
+
+// submitIngest sends an ingest request to Piazza, streaming fileData with it
+// if it is not nil, and waits for the resulting data ID.  spatMeta may be nil.
+func submitIngest(s Session, fName, fType, sourceName, version string,
+	dType DataType,
+	spatMeta *SpatMeta,
+	host bool,
+	fileData *io.SectionReader,
+	props map[string]string) (string, LoggedError) {
+
+	var (
+		resp     *http.Response
+		pErr     *PzCustomError
+		targAddr string
+	)
+
+	desc := fmt.Sprintf("%s uploaded by %s.", fType, sourceName)
+	if !host {
+		desc = fmt.Sprintf("%s registered by %s.", fType, sourceName)
+	}
+	if s.Class == "" {
+		// Better no data than data marked lower than it should be
+		return "", LogSimpleErr(s, `Cannot ingest "`+fName+`": session has no classification.`, nil)
+	}
+	rMeta := ResMeta{
+		Name:        fName,
+		Format:      fType,
+		ClassType:   ClassType{s.Class},
+		Version:     version,
+		Description: desc,
+		Metadata:    make(map[string]string)}
+
+	for key, val := range props {
+		rMeta.Metadata[key] = val
 	}
 
-	dRes := DataDesc{"", dType, rMeta, nil}
-	jType := IngestReq{dRes, true, "ingest"}
+	dRes := DataDesc{"", dType, rMeta, spatMeta}
+	jType := IngestReq{dRes, host, "ingest"}
 	bbuff, err := json.Marshal(jType)
 	if err != nil {
 		return "", LogSimpleErr(s, "Internal Error.  Failure when marshalling IngestReq: ", err)
@@ -87,11 +190,14 @@ func Ingest(s Session, fName, fType, sourceName, version string,
 		targAddr = s.PzAddr + "/data/file"
 		LogInfo(s, "beginning file upload")
 		LogAudit(s, s.UserID, "file upload http request", targAddr, string(bbuff), INFO)
-		resp, pErr = SubmitMultipart(string(bbuff), targAddr, fName, s.PzAuth, fileData)
+		onProgress := func(sent, total int64) {
+			LogInfo(s, fmt.Sprintf("uploaded %d of %d bytes of %s", sent, total, fName))
+		}
+		resp, pErr = submitMultipart(s.Context, s.Span, string(bbuff), targAddr, fName, s.PzAuth, fileData, fileData.Size(), onProgress)
 	} else {
 		targAddr = s.PzAddr + "/data"
 		LogAudit(s, s.UserID, "file upload http request", targAddr, string(bbuff), INFO)
-		resp, pErr = SubmitSinglePart("POST", string(bbuff), targAddr, s.PzAuth)
+		resp, pErr = submitSinglePart(s.Span, "POST", string(bbuff), targAddr, s.PzAuth)
 	}
 	if pErr != nil {
 		return "", pErr.Log(s, "Failure submitting Ingest request")
@@ -117,12 +223,84 @@ func IngestFile(s Session, fName, fType, sourceName, version string,
 	path := locString(s.SubFold, fName)
 
 	LogAudit(s, s.UserID, "read file for ingest", path, "", INFO)
-	fData, err := ioutil.ReadFile(path)
+	file, err := os.Open(path)
+	if err != nil {
+		return "", LogSimpleErr(s, `Error reading file `+fName+` for Ingest: `, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
 	if err != nil {
 		return "", LogSimpleErr(s, `Error reading file `+fName+` for Ingest: `, err)
 	}
-	if len(fData) == 0 {
+	if info.Size() == 0 {
 		return "", LogSimpleErr(s, `File "`+fName+`" read as empty.`, nil)
 	}
-	return Ingest(s, fName, fType, sourceName, version, fData, props)
+	return ingestData(s, fName, fType, sourceName, version, io.NewSectionReader(file, 0, info.Size()), props)
+}
+
+// deployableTypes are the Piazza data types that can be deployed to GeoServer
+var deployableTypes = map[string]bool{"geojson": true, "raster": true, "shapefile": true, "wfs": true}
+
+// IsDeployable returns whether data of the given Piazza type can be deployed
+// to GeoServer
+func IsDeployable(fType string) bool {
+	return deployableTypes[fType]
+}
+
+// DeployToGeoServer asks Piazza to deploy the given data to GeoServer, waits
+// for the deployment to complete, and returns its description, including the
+// name of the layer and the URL of its capabilities document.
+func DeployToGeoServer(s Session, dataID string) (*DeplStrct, LoggedError) {
+	if dataID == "" {
+		return nil, LogSimpleErr(s, `Cannot deploy to GeoServer: no data ID given.`, nil)
+	}
+
+	bbuff, err := json.Marshal(DeployReq{Type: "access", DataID: dataID, DeploymentType: "geoserver"})
+	if err != nil {
+		return nil, LogSimpleErr(s, "Internal Error.  Failure when marshalling DeployReq: ", err)
+	}
+
+	targAddr := s.PzAddr + "/deployment"
+	LogAudit(s, s.UserID, "deployment http request", targAddr, string(bbuff), INFO)
+	resp, pErr := submitSinglePart(s.Span, "POST", string(bbuff), targAddr, s.PzAuth)
+	if pErr != nil {
+		return nil, pErr.Log(s, "Failure submitting deployment request")
+	}
+	LogAuditResponse(s, targAddr, "deployment http response", s.UserID, resp, INFO)
+
+	jobID, pErr := GetJobID(resp)
+	if pErr != nil {
+		return nil, pErr.Log(s, "Failure pulling Job ID for deployment request")
+	}
+
+	result, pErr := GetJobResponse(s, jobID)
+	if pErr != nil {
+		return nil, pErr.Log(s, "Failure getting job result for deployment of "+dataID)
+	}
+	if result.Deployment.Layer == "" {
+		return nil, LogSimpleErr(s, "Deployment of "+dataID+" completed without naming its layer.", nil)
+	}
+	if result.Deployment.DataID == "" {
+		result.Deployment.DataID = dataID
+	}
+	return &result.Deployment, nil
+}
+
+// checkZippedShapefile returns an error unless the data is a zip archive
+// holding a shapefile, with its .shx and .dbf sidecars
+func checkZippedShapefile(data *io.SectionReader) error {
+	reader, err := zip.NewReader(data, data.Size())
+	if err != nil {
+		return errors.New("not a zip archive")
+	}
+	found := map[string]bool{}
+	for _, file := range reader.File {
+		found[strings.ToLower(filepath.Ext(file.Name))] = true
+	}
+	for _, ext := range []string{".shp", ".shx", ".dbf"} {
+		if !found[ext] {
+			return errors.New("archive has no " + ext + " file")
+		}
+	}
+	return nil
 }
@@ -15,6 +15,9 @@
 package pzsvc
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -33,7 +36,7 @@ func TestIngestFile(t *testing.T) {
 	authKey := "testAuthKey"
 	fileName := "tempTestFile.tmp"
 	subFold := "folderName"
-	s := Session{SubFold: subFold, PzAddr: url, PzAuth: authKey, LogAudit: true}
+	s := Session{SubFold: subFold, PzAddr: url, PzAuth: authKey, LogAudit: true, Class: "UNCLASSIFIED"}
 
 	os.Mkdir(subFold, 0777)
 	err := ioutil.WriteFile("./"+subFold+"/"+fileName, []byte(fileName), 0666)
@@ -54,3 +57,99 @@ func TestIngestFile(t *testing.T) {
 	}
 	os.RemoveAll(subFold)
 }
+
+func TestIngestTypes(t *testing.T) {
+	outStrs := []string{
+		`{"Data":{"JobID":"testID1"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"shpID"}}}`,
+		`{"Data":{"JobID":"testID2"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"lasID"}}}`,
+		`{"Data":{"JobID":"testID3"}}`,
+		`{"Data":{"Status":"Success", "Result":{"DataID":"wfsID"}}}`}
+	SetMockClient(outStrs, 250)
+	s := Session{PzAddr: "http://testURL.net", PzAuth: "testAuthKey", Class: "SECRET"}
+	props := map[string]string{"prop1": "1"}
+
+	var shpZip bytes.Buffer
+	zipWriter := zip.NewWriter(&shpZip)
+	for _, name := range []string{"roads.shp", "roads.shx", "roads.dbf"} {
+		zipWriter.Create(name)
+	}
+	zipWriter.Close()
+
+	if dataID, err := Ingest(s, "roads.zip", "shapefile", "tester", "0.0", shpZip.Bytes(), props); err != nil || dataID != "shpID" {
+		t.Errorf(`TestIngestTypes: shapefile ingest gave "%s", %v`, dataID, err)
+	}
+	if dataID, err := Ingest(s, "cloud.laz", "pointcloud", "tester", "0.0", []byte("LASF\x00\x01"), props); err != nil || dataID != "lasID" {
+		t.Errorf(`TestIngestTypes: pointcloud ingest gave "%s", %v`, dataID, err)
+	}
+	wfs := DataType{Type: "wfs", URL: "https://example.com/wfs", FeatureType: "roads", Version: "1.0.0"}
+	if dataID, err := IngestReference(s, "roads", "tester", "0.0", wfs, props); err != nil || dataID != "wfsID" {
+		t.Errorf(`TestIngestTypes: wfs registration gave "%s", %v`, dataID, err)
+	}
+
+	if _, err := Ingest(s, "roads.shp", "shapefile", "tester", "0.0", []byte{0x00, 0x00, 0x27, 0x0a}, props); err == nil {
+		t.Error("TestIngestTypes: accepted an unzipped shapefile")
+	}
+	if _, err := Ingest(s, "cloud.las", "pointcloud", "tester", "0.0", []byte("not a point cloud"), props); err == nil {
+		t.Error("TestIngestTypes: accepted a bad point cloud")
+	}
+	if _, err := Ingest(Session{PzAddr: "http://testURL.net"}, "notes.txt", "text", "tester", "0.0", []byte("x"), props); err == nil {
+		t.Error("TestIngestTypes: ingested data without a classification")
+	}
+	if _, err := Ingest(s, "table", "postgis", "tester", "0.0", []byte("x"), props); err == nil {
+		t.Error("TestIngestTypes: accepted an unsupported upload type")
+	}
+	if _, err := IngestReference(s, "roads", "tester", "0.0", DataType{Type: "wfs", URL: "https://example.com/wfs"}, props); err == nil {
+		t.Error("TestIngestTypes: accepted wfs without a feature type")
+	}
+	if _, err := IngestReference(s, "roads", "tester", "0.0", DataType{Type: "wfs", URL: "file:///etc/passwd", FeatureType: "roads"}, props); err == nil {
+		t.Error("TestIngestTypes: accepted a non-http URL")
+	}
+}
+
+func TestDeployToGeoServer(t *testing.T) {
+	outStrs := []string{
+		`{"Data":{"JobID":"testID1"}}`,
+		`{"Data":{"Status":"Success", "Result":{"deployment":{"deploymentId":"deplID","layer":"layerName","capabilitiesUrl":"http://geoserver/wms?request=GetCapabilities"}}}}`,
+		`{"Data":{"JobID":"testID2"}}`,
+		`{"Data":{"Status":"Success", "Result":{"deployment":{"deploymentId":"deplID2"}}}}`}
+	SetMockClient(outStrs, 250)
+	s := Session{PzAddr: "http://testURL.net", PzAuth: "testAuthKey"}
+
+	deployment, err := DeployToGeoServer(s, "dataID")
+	if err != nil || deployment.Layer != "layerName" || deployment.DataID != "dataID" || deployment.CapabilitiesURL == "" {
+		t.Errorf(`TestDeployToGeoServer: deployment gave %+v, %v`, deployment, err)
+	}
+	if _, err = DeployToGeoServer(s, "dataID"); err == nil {
+		t.Error("TestDeployToGeoServer: accepted a deployment without a layer")
+	}
+	if _, err = DeployToGeoServer(s, ""); err == nil {
+		t.Error("TestDeployToGeoServer: accepted a blank data ID")
+	}
+	if IsDeployable("text") || !IsDeployable("raster") {
+		t.Error("TestDeployToGeoServer: IsDeployable gave the wrong answer")
+	}
+}
+
+func TestOutFileJSON(t *testing.T) {
+	var inp InpStruct
+	err := json.Unmarshal([]byte(`{"outTiffs":["a.tif"],"outputs":["b.txt",{"name":"c.tif","deploy":true},{"name":"d.txt"}]}`), &inp)
+	if err != nil {
+		t.Fatal(`TestOutFileJSON: ` + err.Error())
+	}
+	expected := []OutFile{{Name: "b.txt"}, {Name: "c.tif", Deploy: true}, {Name: "d.txt"}}
+	if len(inp.OutTiffs) != 1 || inp.OutTiffs[0] != "a.tif" || len(inp.Outputs) != 3 || inp.Outputs[0] != expected[0] || inp.Outputs[1] != expected[1] || inp.Outputs[2] != expected[2] {
+		t.Errorf(`TestOutFileJSON: got %+v`, inp)
+	}
+	byts, _ := json.Marshal(inp.Outputs)
+	if string(byts) != `["b.txt",{"name":"c.tif","deploy":true},"d.txt"]` {
+		t.Errorf(`TestOutFileJSON: marshalled as %s`, byts)
+	}
+	if err = json.Unmarshal([]byte(`{"outputs":[3]}`), &inp); err == nil {
+		t.Error("TestOutFileJSON: accepted a number as an output")
+	}
+	if err = json.Unmarshal([]byte(`{"outTiffs":[{"name":"a.tif"}]}`), &inp); err == nil {
+		t.Error("TestOutFileJSON: accepted an object in outTiffs")
+	}
+}
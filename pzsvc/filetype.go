@@ -0,0 +1,139 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is read to detect its type
+const sniffLen = 4096
+
+var (
+	tiffMagics = [][]byte{
+		[]byte("II*\x00"), []byte("MM\x00*"), // TIFF
+		[]byte("II+\x00"), []byte("MM\x00+"), // BigTIFF
+	}
+	zipMagics = [][]byte{[]byte("PK\x03\x04"), []byte("PK\x05\x06")}
+	lasMagic  = []byte("LASF")
+	shpMagic  = []byte{0x00, 0x00, 0x27, 0x0a}
+	utf8BOM   = []byte("\xef\xbb\xbf")
+
+	geoJSONTypeRegexp = regexp.MustCompile(`"type"\s*:\s*"(?:FeatureCollection|Feature|GeometryCollection|(?:Multi)?(?:Point|LineString|Polygon))"`)
+)
+
+// DetectFileType returns the Piazza data type ('raster', 'geojson',
+// 'shapefile', 'pointcloud' or 'text') of the file at the given path, judged
+// by its content and its extension.  Binary files of no type that Piazza can
+// ingest are an error, rather than being passed off as text.
+func DetectFileType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	head = head[:n]
+	ext := strings.ToLower(filepath.Ext(path))
+
+	switch {
+	case hasAnyPrefix(head, tiffMagics):
+		return "raster", nil
+	case bytes.HasPrefix(head, lasMagic):
+		return "pointcloud", nil
+	case bytes.HasPrefix(head, shpMagic):
+		return "", fmt.Errorf("%s is a bare shapefile, which Piazza cannot ingest without its .shx and .dbf files; zip them together", path)
+	case hasAnyPrefix(head, zipMagics):
+		if zipHasShapefile(path) {
+			return "shapefile", nil
+		}
+		return "", fmt.Errorf("%s is a zip archive without a shapefile, which Piazza cannot ingest", path)
+	case IsText(head, n == sniffLen):
+		if looksLikeGeoJSON(head, ext) {
+			return "geojson", nil
+		}
+		return "text", nil
+	}
+	return "", fmt.Errorf("%s is binary content of no type that Piazza can ingest", path)
+}
+
+// IsText reports whether the data looks like text: valid UTF-8 with no NUL
+// bytes.  If truncated is true, the data is the start of something longer,
+// and may end partway through a character.
+func IsText(data []byte, truncated bool) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	if truncated {
+		// Drop a character cut off at the end, if there is one
+		for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+			if utf8.RuneStart(data[len(data)-i]) {
+				if !utf8.FullRune(data[len(data)-i:]) {
+					data = data[:len(data)-i]
+				}
+				break
+			}
+		}
+	}
+	return utf8.Valid(data)
+}
+
+// looksLikeGeoJSON reports whether the start of a text file is a JSON object
+// that declares a GeoJSON type.  Files named .geojson need only be JSON
+// objects.
+func looksLikeGeoJSON(head []byte, ext string) bool {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(head, utf8BOM))
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		return false
+	}
+	return ext == ".geojson" || geoJSONTypeRegexp.Match(trimmed)
+}
+
+// zipHasShapefile reports whether the zip archive at the given path holds a
+// .shp file
+func zipHasShapefile(path string) bool {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if strings.ToLower(filepath.Ext(file.Name)) == ".shp" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(data []byte, prefixes [][]byte) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+	return false
+}
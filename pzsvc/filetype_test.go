@@ -0,0 +1,96 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFileType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetype")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var shpZip, otherZip []byte
+	for _, name := range []string{"roads.shp", "notes.txt"} {
+		path := filepath.Join(dir, name+".zip")
+		file, _ := os.Create(path)
+		zipWriter := zip.NewWriter(file)
+		zipWriter.Create(name)
+		zipWriter.Close()
+		file.Close()
+		data, _ := ioutil.ReadFile(path)
+		if name == "roads.shp" {
+			shpZip = data
+		} else {
+			otherZip = data
+		}
+	}
+
+	inputs := map[string][]byte{
+		"out.tif":      []byte("II*\x00\x08\x00\x00\x00"),
+		"big.tif":      []byte("MM\x00+\x00\x08\x00\x00"),
+		"cloud.laz":    []byte("LASF\x00\x00\x01\x02"),
+		"roads.shp":    {0x00, 0x00, 0x27, 0x0a, 0x00, 0x00},
+		"roads.zip":    shpZip,
+		"out.json":     []byte(` {"type": "FeatureCollection", "features": []}`),
+		"out.geojson":  []byte(`{"features": [], "type": "FeatureCollection"}`),
+		"other.json":   []byte(`{"type": "config"}`),
+		"readme":       []byte("plain text, ünïcode"),
+		"notes.zip":    otherZip,
+		"mystery.data": {0x7f, 0x45, 0x4c, 0x46, 0x00, 0x01},
+	}
+	expected := map[string]string{
+		"out.tif":      "raster",
+		"big.tif":      "raster",
+		"cloud.laz":    "pointcloud",
+		"roads.shp":    "",
+		"roads.zip":    "shapefile",
+		"out.json":     "geojson",
+		"out.geojson":  "geojson",
+		"other.json":   "text",
+		"readme":       "text",
+		"notes.zip":    "",
+		"mystery.data": "",
+	}
+	for name, data := range inputs {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := DetectFileType(path)
+		if actual != expected[name] {
+			t.Errorf(`TestDetectFileType: for %s, expected "%s", got "%s"`, name, expected[name], actual)
+		}
+		if (err != nil) != (expected[name] == "") {
+			t.Errorf(`TestDetectFileType: for %s, unexpected error result: %v`, name, err)
+		}
+	}
+}
+
+func TestIsText(t *testing.T) {
+	if !IsText([]byte("caf\xc3\xa9"), false) || !IsText([]byte("caf\xc3"), true) {
+		t.Error("TestIsText: rejected text")
+	}
+	if IsText([]byte("caf\xc3"), false) || IsText([]byte("ab\x00cd"), false) {
+		t.Error("TestIsText: accepted binary")
+	}
+}
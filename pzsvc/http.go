@@ -16,6 +16,7 @@ package pzsvc
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"io"
@@ -52,7 +53,11 @@ func SetHTTPClient(newClient *http.Client) {
 // the get request, unmarshal the result into the given object, and return. It
 // returns the response buffer, in case it is needed for debugging purposes.
 func RequestKnownJSON(method, bodyStr, address, authKey string, outpObj interface{}) ([]byte, *PzCustomError) {
-	resp, err := SubmitSinglePart(method, bodyStr, address, authKey)
+	return requestKnownJSON(nil, method, bodyStr, address, authKey, outpObj)
+}
+
+func requestKnownJSON(span *Span, method, bodyStr, address, authKey string, outpObj interface{}) ([]byte, *PzCustomError) {
+	resp, err := submitSinglePart(span, method, bodyStr, address, authKey)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -69,49 +74,65 @@ func RequestKnownJSON(method, bodyStr, address, authKey string, outpObj interfac
 // SubmitMultipart sends a multi-part POST call, including an optional uploaded file,
 // and returns the response.  Primarily intended to support Ingest calls.
 func SubmitMultipart(bodyStr, address, filename, authKey string, fileData []byte) (*http.Response, *PzCustomError) {
-
-	var (
-		body   = &bytes.Buffer{}
-		writer = multipart.NewWriter(body)
-		client = HTTPClient()
-		err    error
-	)
-
-	err = writer.WriteField("data", bodyStr)
-	if err != nil {
-		return nil, &PzCustomError{LogMsg: "Could not write string " + bodyStr + "to message body: " + err.Error(), SimpleMsg: "Internal Error on file upload.  See logs."}
+	if fileData == nil {
+		return submitMultipart(nil, nil, bodyStr, address, filename, authKey, nil, 0, nil)
 	}
+	return submitMultipart(nil, nil, bodyStr, address, filename, authKey, bytes.NewReader(fileData), int64(len(fileData)), nil)
+}
 
-	if fileData != nil {
-		var part io.Writer
-		part, err = writer.CreateFormFile("file", filename)
-		if err != nil {
-			return nil, &PzCustomError{LogMsg: "Error on CreateFormFile: " + err.Error(), SimpleMsg: "Internal Error on file upload.  See logs."}
-		}
-		if part == nil {
-			return nil, &PzCustomError{LogMsg: "CreateFormFile returned empty form.", SimpleMsg: "Internal Error on file upload.  See logs."}
-		}
+// submitMultipart streams a multi-part POST call, reading the uploaded file,
+// if any, as the request is sent.  The framing around the file is written up
+// front, so that the request carries a Content-Length without the file being
+// held in memory.  If ctx is not nil, cancelling it aborts the upload.  If
+// onProgress is not nil, it is called as the file is read.
+func submitMultipart(ctx context.Context, span *Span, bodyStr, address, filename, authKey string,
+	file io.Reader, size int64, onProgress func(sent, total int64)) (*http.Response, *PzCustomError) {
 
-		_, err = io.Copy(part, bytes.NewReader(fileData))
-		if err != nil {
-			return nil, &PzCustomError{LogMsg: "Error on file data Copy: " + err.Error(), SimpleMsg: "Internal Error on file upload.  See logs."}
-		}
+	client := HTTPClient()
+
+	if file != nil && size < 0 {
+		return nil, &PzCustomError{LogMsg: "Cannot upload file " + filename + " of unknown size.", SimpleMsg: "Internal Error on file upload.  See logs."}
+	}
+	if file != nil && onProgress != nil {
+		file = newProgressReader(file, size, onProgress)
 	}
 
-	err = writer.Close()
+	var framing bytes.Buffer
+	writer := multipart.NewWriter(&framing)
+	if _, pErr := writeMultipartHead(writer, bodyStr, filename, file != nil); pErr != nil {
+		return nil, pErr
+	}
+	headLen := framing.Len()
+	err := writer.Close()
 	if err != nil {
 		return nil, &PzCustomError{LogMsg: "Error on Writer close: " + err.Error(), SimpleMsg: "Internal Error on file upload.  See logs."}
 	}
+	head, tail := framing.Bytes()[:headLen], framing.Bytes()[headLen:]
+	contentLength := int64(framing.Len())
+	readers := []io.Reader{bytes.NewReader(head)}
+	if file != nil {
+		readers = append(readers, io.LimitReader(file, size))
+		contentLength += size
+	}
+	body := io.MultiReader(append(readers, bytes.NewReader(tail))...)
 
 	fileReq, err := http.NewRequest("POST", address, body)
 	if err != nil {
 		return nil, &PzCustomError{LogMsg: "Error on Request creation: " + err.Error(), SimpleMsg: "Internal Error on file upload.  See logs."}
 	}
+	if ctx != nil {
+		fileReq = fileReq.WithContext(ctx)
+	}
+	fileReq.ContentLength = contentLength
 
 	fileReq.Header.Add("Content-Type", writer.FormDataContentType())
 	fileReq.Header.Add("Authorization", authKey)
 
+	httpSpan := startHTTPSpan(span, fileReq)
+	start := time.Now()
 	resp, err := client.Do(fileReq)
+	httpDuration.ObserveSince(start, "POST", metricEndpoint(address))
+	finishHTTPSpan(httpSpan, resp, err)
 	if err != nil {
 		return nil, &PzCustomError{LogMsg: "Error on POST multipart: " + err.Error(), url: address, request: bodyStr, SimpleMsg: "HTTP error on file upload.  See logs."}
 	}
@@ -124,9 +145,71 @@ func SubmitMultipart(bodyStr, address, filename, authKey string, fileData []byte
 	return resp, nil
 }
 
+// writeMultipartHead writes the data field of a multi-part call, and the
+// header of its file part if it has one.  Returns the writer for the file.
+func writeMultipartHead(writer *multipart.Writer, bodyStr, filename string, withFile bool) (io.Writer, *PzCustomError) {
+	err := writer.WriteField("data", bodyStr)
+	if err != nil {
+		return nil, &PzCustomError{LogMsg: "Could not write string " + bodyStr + "to message body: " + err.Error(), SimpleMsg: "Internal Error on file upload.  See logs."}
+	}
+	if !withFile {
+		return nil, nil
+	}
+
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, &PzCustomError{LogMsg: "Error on CreateFormFile: " + err.Error(), SimpleMsg: "Internal Error on file upload.  See logs."}
+	}
+	if part == nil {
+		return nil, &PzCustomError{LogMsg: "CreateFormFile returned empty form.", SimpleMsg: "Internal Error on file upload.  See logs."}
+	}
+	return part, nil
+}
+
+// progressReader reports the progress of reading through it, at every tenth
+// of the total
+type progressReader struct {
+	reader io.Reader
+	total  int64
+	sent   int64
+	step   int64
+	next   int64
+	report func(sent, total int64)
+}
+
+func newProgressReader(reader io.Reader, total int64, report func(sent, total int64)) *progressReader {
+	step := (total + 9) / 10
+	if step <= 0 {
+		step = 1
+	}
+	return &progressReader{reader: reader, total: total, step: step, next: step, report: report}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.sent += int64(n)
+	if n > 0 && r.sent >= r.next {
+		r.report(r.sent, r.total)
+		for r.next <= r.sent {
+			r.next += r.step
+		}
+	}
+	return n, err
+}
+
 // SubmitSinglePart sends a single-part GET/POST/PUT/DELETE call to the target URL
 // and returns the result.  Includes the necessary headers.
 func SubmitSinglePart(method, bodyStr, url, authKey string) (*http.Response, *PzCustomError) {
+	return submitSinglePart(nil, method, bodyStr, url, authKey)
+}
+
+func submitSinglePart(span *Span, method, bodyStr, url, authKey string) (*http.Response, *PzCustomError) {
+	return submitSinglePartContext(nil, span, method, bodyStr, url, authKey)
+}
+
+// submitSinglePartContext is submitSinglePart, aborting the call if the
+// given context, which may be nil, is cancelled or runs out of time
+func submitSinglePartContext(ctx context.Context, span *Span, method, bodyStr, url, authKey string) (*http.Response, *PzCustomError) {
 
 	var (
 		fileReq *http.Request
@@ -152,8 +235,15 @@ func SubmitSinglePart(method, bodyStr, url, authKey string) (*http.Response, *Pz
 	}
 
 	fileReq.Header.Add("Authorization", authKey)
+	if ctx != nil {
+		fileReq = fileReq.WithContext(ctx)
+	}
 
+	httpSpan := startHTTPSpan(span, fileReq)
+	start := time.Now()
 	resp, err := client.Do(fileReq)
+	httpDuration.ObserveSince(start, method, metricEndpoint(url))
+	finishHTTPSpan(httpSpan, resp, err)
 	if err != nil {
 		return nil, &PzCustomError{LogMsg: err.Error(), request: bodyStr}
 	}
@@ -183,7 +273,7 @@ func GetJobResponse(s Session, jobID string) (*DataResult, *PzCustomError) {
 		}
 		targAddr := s.PzAddr + "/job/" + jobID
 		LogAudit(s, s.UserID, "http call - Checking job status - request", targAddr, "", INFO)
-		respBuf, err := RequestKnownJSON("GET", "", targAddr, s.PzAuth, &outpObj)
+		respBuf, err := requestKnownJSON(s.Span, "GET", "", targAddr, s.PzAuth, &outpObj)
 		if err != nil {
 			return nil, err
 		}
@@ -195,7 +285,11 @@ func GetJobResponse(s Session, jobID string) (*DataResult, *PzCustomError) {
 			respObj.Status == "Pending" ||
 			(respObj.Status == "Success" && respObj.Result == nil) ||
 			(respObj.Status == "Error" && respObj.Result.Message == "Job Not Found.") {
-			time.Sleep(time.Second)
+			select {
+			case <-time.After(time.Second):
+			case <-s.done():
+				return nil, &PzCustomError{LogMsg: "Stopped waiting for job " + jobID + ": " + s.Context.Err().Error()}
+			}
 		} else {
 			if respObj.Status == "Success" {
 				return respObj.Result, nil
@@ -213,6 +307,27 @@ func GetJobResponse(s Session, jobID string) (*DataResult, *PzCustomError) {
 	return nil, &PzCustomError{LogMsg: "Job never completed.  JobId: " + jobID}
 }
 
+// GetJobStatus makes a single request for the current status of the given
+// job, without waiting for it to complete.
+func GetJobStatus(s Session, jobID string) (*JobStatusResp, *PzCustomError) {
+	if jobID == "" {
+		return nil, &PzCustomError{LogMsg: `JobID not provided.  Cannot get Job Status.`}
+	}
+
+	var outpObj struct {
+		Data JobStatusResp `json:"data,omitempty"`
+	}
+	targAddr := s.PzAddr + "/job/" + jobID
+	LogAudit(s, s.UserID, "http call - Checking job status - request", targAddr, "", INFO)
+	respBuf, err := requestKnownJSON(s.Span, "GET", "", targAddr, s.PzAuth, &outpObj)
+	if err != nil {
+		return nil, err
+	}
+	LogAudit(s, targAddr, "http call - Checking job status - response", s.UserID, string(respBuf), INFO)
+
+	return &outpObj.Data, nil
+}
+
 // GetJobID is a simple function to extract the job ID from
 // the standard response to job-creating Pz calls
 func GetJobID(resp *http.Response) (string, *PzCustomError) {
@@ -241,11 +356,15 @@ func ReadBodyJSON(output interface{}, body io.ReadCloser) ([]byte, *PzCustomErro
 }
 
 // CheckAuth verifies that the given API key is valid for the given
-// Piazza address
+// Piazza address.  It asks for a single service, so that the check stays
+// cheap, and gives up when the session's Context does.
 func CheckAuth(s Session) *PzCustomError {
-	targURL := s.PzAddr + "/service"
+	targURL := s.PzAddr + "/service?perPage=1"
 	LogAudit(s, s.UserID, "verify Piazza auth key request", targURL, "", INFO)
-	_, err := SubmitSinglePart("GET", "", targURL, s.PzAuth)
+	resp, err := submitSinglePartContext(s.Context, s.Span, "GET", "", targURL, s.PzAuth)
+	if resp != nil {
+		resp.Body.Close()
+	}
 	if err != nil {
 		return &PzCustomError{LogMsg: "Could not confirm user authorization."}
 	}
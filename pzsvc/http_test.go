@@ -15,10 +15,16 @@
 package pzsvc
 
 import (
+	"context"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestSubmitSinglePart(t *testing.T) {
@@ -84,6 +90,57 @@ func TestSubmitMultipart(t *testing.T) {
 
 }
 
+func TestSubmitMultipartStream(t *testing.T) {
+	type received struct {
+		contentLength int64
+		data, file    string
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := received{contentLength: r.ContentLength}
+		if err := r.ParseMultipartForm(1024); err == nil {
+			got.data = r.FormValue("data")
+			if file, _, err := r.FormFile("file"); err == nil {
+				fileData, _ := ioutil.ReadAll(file)
+				got.file = string(fileData)
+			}
+		}
+		requests <- got
+	}))
+	defer server.Close()
+	SetHTTPClient(&http.Client{})
+	defer SetHTTPClient(nil)
+
+	fileData := strings.Repeat("0123456789", 100)
+	reports := 0
+	onProgress := func(sent, total int64) { reports++ }
+
+	size := int64(len(fileData))
+	_, pErr := submitMultipart(nil, nil, "testBody", server.URL, "name", "testAuthKey", iotest.OneByteReader(strings.NewReader(fileData)), size, onProgress)
+	if pErr != nil {
+		t.Fatalf("TestSubmitMultipartStream: %s", pErr.Error())
+	}
+	got := <-requests
+	if got.data != "testBody" || got.file != fileData {
+		t.Errorf(`TestSubmitMultipartStream: server received data "%s" and %d bytes of file`, got.data, len(got.file))
+	}
+	if got.contentLength <= size {
+		t.Errorf("TestSubmitMultipartStream: sent with Content-Length %d", got.contentLength)
+	}
+	if reports != 10 {
+		t.Errorf("TestSubmitMultipartStream: expected 10 progress reports, got %d", reports)
+	}
+
+	if _, pErr = submitMultipart(nil, nil, "testBody", server.URL, "name", "testAuthKey", strings.NewReader(fileData), -1, nil); pErr == nil {
+		t.Error("TestSubmitMultipartStream: accepted a file of unknown size")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, pErr = submitMultipart(ctx, nil, "testBody", server.URL, "name", "testAuthKey", strings.NewReader(fileData), size, nil); pErr == nil {
+		t.Error("TestSubmitMultipartStream: uploaded despite a cancelled context")
+	}
+}
+
 func TestRequestKnownJSON(t *testing.T) {
 	outStrs := []string{
 		`{"PercentComplete":0, "TimeRemaining":"blah", "TimeSpent":"blah"}`,
@@ -236,6 +293,63 @@ func TestCheckAuth(t *testing.T) {
 	}
 }
 
+// closeRecorder is a response body that records whether it was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+type closeRecorderTransport struct {
+	body *closeRecorder
+	url  *string
+}
+
+func (t closeRecorderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*t.url = req.URL.String()
+	return &http.Response{StatusCode: 200, Header: make(http.Header), Body: t.body, Request: req}, nil
+}
+
+func TestCheckAuthProbe(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader(`{}`)}
+	var reqURL string
+	SetHTTPClient(&http.Client{Transport: closeRecorderTransport{body: body, url: &reqURL}})
+	defer SetHTTPClient(nil)
+	if err := CheckAuth(Session{PzAddr: "http://pz.test", PzAuth: "testAuth"}); err != nil {
+		t.Fatal("TestCheckAuthProbe: " + err.Error())
+	}
+	if !body.closed {
+		t.Error("TestCheckAuthProbe: response body left open.")
+	}
+	if reqURL != "http://pz.test/service?perPage=1" {
+		t.Error("TestCheckAuthProbe: probed " + reqURL)
+	}
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	SetHTTPClient(&http.Client{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := CheckAuth(Session{PzAddr: server.URL, PzAuth: "testAuth", Context: ctx}); err == nil {
+		t.Error("TestCheckAuthProbe: no error from a probe that timed out.")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("TestCheckAuthProbe: probe took %v despite its deadline.", elapsed)
+	}
+}
+
 func TestTestUtils(t *testing.T) {
 	testData := []byte("testtesttest")
 	mockRespWrite, _, _ := GetMockResponseWriter()
@@ -260,3 +374,24 @@ func TestUtils(t *testing.T) {
 	t.Log(SliceToCommaSep(uuidSlice))
 
 }
+
+func TestGetJobStatus(t *testing.T) {
+	outStrs := []string{`{"Data":{"Status":"Running"}}`, `XXXXX`}
+	SetMockClient(outStrs, 250)
+	s := Session{PzAuth: "testAuthKey", PzAddr: "http://testURL.net"}
+
+	status, err := GetJobStatus(s, "testJobID")
+	if err != nil {
+		t.Error(`TestGetJobStatus: failed on what should have been clean run.`)
+	} else if PiazzaStatus(status.Status) != PiazzaStatusRunning || PiazzaStatus(status.Status).IsFinal() {
+		t.Error(`TestGetJobStatus: did not read status properly.`)
+	}
+	_, err = GetJobStatus(s, "testJobID")
+	if err == nil {
+		t.Error(`TestGetJobStatus: passed on bad JSON.`)
+	}
+	_, err = GetJobStatus(s, "")
+	if err == nil {
+		t.Error(`TestGetJobStatus: passed without job ID.`)
+	}
+}
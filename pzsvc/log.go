@@ -0,0 +1,224 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The output formats available for log entries
+const (
+	LogFormatSyslog = "syslog" // RFC 5424 style, as used by Piazza
+	LogFormatJSON   = "json"   // one JSON object per line
+	LogFormatLogfmt = "logfmt" // key=value pairs
+)
+
+var (
+	logFormat = LogFormatSyslog
+	logLevel  = DEBUG
+)
+
+var severityNames = map[int]string{
+	FATAL:    "fatal",
+	1:        "alert",
+	CRITICAL: "critical",
+	ERROR:    "error",
+	WARN:     "warn",
+	NOTICE:   "notice",
+	INFO:     "info",
+	DEBUG:    "debug",
+}
+
+// logEntry is a single log message, along with everything known about where
+// it came from
+type logEntry struct {
+	Time     time.Time
+	Severity int
+	Host     string
+	App      string
+	PID      int
+	File     string
+	Line     int
+	Function string
+	Message  string
+	Fields   map[string]string
+	Audit    *auditDetails
+}
+
+// auditDetails are the parts of an audit log entry beyond its message
+type auditDetails struct {
+	Actor  string
+	Action string
+	Actee  string
+}
+
+// redact returns a copy of the details with secrets scrubbed
+func (ad *auditDetails) redact() *auditDetails {
+	if ad == nil {
+		return nil
+	}
+	return &auditDetails{Actor: Redact(ad.Actor), Action: Redact(ad.Action), Actee: Redact(ad.Actee)}
+}
+
+// SetupLogging sets the format and minimum severity of log output, from the
+// LogFormat and LogLevel settings of the given config.  Blank settings leave
+// the current values alone.  Audit entries are never filtered by level.
+func SetupLogging(config Config) error {
+	if config.LogFormat != "" {
+		switch config.LogFormat {
+		case LogFormatSyslog, LogFormatJSON, LogFormatLogfmt:
+			logFormat = config.LogFormat
+		default:
+			return fmt.Errorf("unknown LogFormat %q", config.LogFormat)
+		}
+	}
+	if config.LogLevel != "" {
+		level, ok := parseLogLevel(config.LogLevel)
+		if !ok {
+			return fmt.Errorf("unknown LogLevel %q", config.LogLevel)
+		}
+		logLevel = level
+	}
+	return nil
+}
+
+func parseLogLevel(name string) (int, bool) {
+	name = strings.ToLower(name)
+	if name == "warning" {
+		name = "warn"
+	}
+	for level, levelName := range severityNames {
+		if levelName == name {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// WithField returns a copy of the session that adds the given field to every
+// log entry made through it.  The original session is not changed.
+func (s Session) WithField(key, value string) Session {
+	fields := make(map[string]string, len(s.LogFields)+1)
+	for k, v := range s.LogFields {
+		fields[k] = v
+	}
+	fields[key] = value
+	s.LogFields = fields
+	return s
+}
+
+// sortedKeys returns the keys of the given map, in order
+func sortedKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// format renders the entry in the current log format
+func (le logEntry) format() string {
+	switch logFormat {
+	case LogFormatJSON:
+		return le.formatJSON()
+	case LogFormatLogfmt:
+		return le.formatLogfmt()
+	}
+	return le.formatSyslog()
+}
+
+func (le logEntry) timeString() string {
+	return le.Time.UTC().Format("2006-01-02T15:04:05.999Z")
+}
+
+func (le logEntry) formatSyslog() string {
+	sdata := fmt.Sprintf(`[pzsource@48851 file="%s" line="%d" function="%s"]`, le.File, le.Line, le.Function)
+	if len(le.Fields) > 0 {
+		pairs := []string{}
+		for _, key := range sortedKeys(le.Fields) {
+			pairs = append(pairs, key+`="`+syslogEscape(le.Fields[key])+`"`)
+		}
+		sdata += "[pzfields@48851 " + strings.Join(pairs, " ") + "]"
+	}
+	msg := le.Message
+	if le.Audit != nil {
+		msg = fmt.Sprintf(`[pzaudit@48851 actor="%s" action="%s" actee="%s"] %s`, le.Audit.Actor, le.Audit.Action, le.Audit.Actee, msg)
+	}
+	return fmt.Sprintf(`<%d>1 %s %s %s - ID%d %s %s`,
+		8+le.Severity, le.timeString(), le.Host, le.App, le.PID, sdata, msg)
+}
+
+func syslogEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+func (le logEntry) formatJSON() string {
+	out := map[string]interface{}{}
+	for key, value := range le.Fields {
+		out[key] = value
+	}
+	out["time"] = le.timeString()
+	out["level"] = severityNames[le.Severity]
+	out["host"] = le.Host
+	out["app"] = le.App
+	out["pid"] = le.PID
+	out["file"] = le.File
+	out["line"] = le.Line
+	out["function"] = le.Function
+	out["msg"] = le.Message
+	if le.Audit != nil {
+		out["audit"] = map[string]string{"actor": le.Audit.Actor, "action": le.Audit.Action, "actee": le.Audit.Actee}
+	}
+	byts, _ := json.Marshal(out)
+	return string(byts)
+}
+
+func (le logEntry) formatLogfmt() string {
+	pairs := []string{
+		"time=" + le.timeString(),
+		"level=" + severityNames[le.Severity],
+		"host=" + logfmtValue(le.Host),
+		"app=" + logfmtValue(le.App),
+		"pid=" + strconv.Itoa(le.PID),
+		"file=" + logfmtValue(le.File),
+		"line=" + strconv.Itoa(le.Line),
+		"function=" + logfmtValue(le.Function),
+	}
+	if le.Audit != nil {
+		pairs = append(pairs, "audit=true",
+			"actor="+logfmtValue(le.Audit.Actor),
+			"action="+logfmtValue(le.Audit.Action),
+			"actee="+logfmtValue(le.Audit.Actee))
+	}
+	for _, key := range sortedKeys(le.Fields) {
+		pairs = append(pairs, key+"="+logfmtValue(le.Fields[key]))
+	}
+	pairs = append(pairs, "msg="+logfmtValue(le.Message))
+	return strings.Join(pairs, " ")
+}
+
+// logfmtValue quotes the given value if it needs it
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,89 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func captureLogs(t *testing.T, config Config) (*[]string, *[]string, func()) {
+	logs, audits := []string{}, []string{}
+	oldLog, oldAudit, oldFormat, oldLevel := LogFunc, AuditLogFunc, logFormat, logLevel
+	LogFunc = func(msg string) { logs = append(logs, msg) }
+	AuditLogFunc = func(msg string) { audits = append(audits, msg) }
+	if err := SetupLogging(config); err != nil {
+		t.Fatal(err)
+	}
+	return &logs, &audits, func() {
+		LogFunc, AuditLogFunc, logFormat, logLevel = oldLog, oldAudit, oldFormat, oldLevel
+	}
+}
+
+func TestLogFormats(t *testing.T) {
+	s := Session{AppName: "testApp", LogAudit: true}.WithField("jobID", "job 1")
+
+	logs, audits, restore := captureLogs(t, Config{LogFormat: LogFormatSyslog})
+	LogInfo(s, "hello")
+	LogAudit(s, "me", "act", "you", "audited", INFO)
+	restore()
+	if len(*logs) != 1 || !strings.HasPrefix((*logs)[0], "<14>1 ") ||
+		!strings.Contains((*logs)[0], `[pzfields@48851 jobID="job 1"] hello`) ||
+		!strings.Contains((*logs)[0], `function="github.com/venicegeo/pzsvc-exec/pzsvc.TestLogFormats"`) {
+		t.Errorf("TestLogFormats: bad syslog output: %v", *logs)
+	}
+	if len(*audits) != 1 || !strings.Contains((*audits)[0], `[pzaudit@48851 actor="me" action="act" actee="you"] audited`) {
+		t.Errorf("TestLogFormats: bad syslog audit output: %v", *audits)
+	}
+
+	logs, audits, restore = captureLogs(t, Config{LogFormat: LogFormatJSON})
+	LogWarn(s, "careful")
+	LogAudit(s, "me", "act", "you", "audited", INFO)
+	restore()
+	var entry map[string]interface{}
+	if len(*logs) != 1 || json.Unmarshal([]byte((*logs)[0]), &entry) != nil ||
+		entry["level"] != "warn" || entry["msg"] != "careful" || entry["jobID"] != "job 1" {
+		t.Errorf("TestLogFormats: bad JSON output: %v", *logs)
+	}
+	if len(*audits) != 1 || !strings.Contains((*audits)[0], `"audit":{"actee":"you","action":"act","actor":"me"}`) {
+		t.Errorf("TestLogFormats: bad JSON audit output: %v", *audits)
+	}
+
+	logs, _, restore = captureLogs(t, Config{LogFormat: LogFormatLogfmt})
+	LogInfo(s, "a=b")
+	restore()
+	if len(*logs) != 1 || !strings.Contains((*logs)[0], ` level=info `) ||
+		!strings.HasSuffix((*logs)[0], ` jobID="job 1" msg="a=b"`) {
+		t.Errorf("TestLogFormats: bad logfmt output: %v", *logs)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	s := Session{AppName: "testApp", LogAudit: true}
+	logs, audits, restore := captureLogs(t, Config{LogLevel: "warn"})
+	defer restore()
+	LogInfo(s, "dropped")
+	LogWarn(s, "kept")
+	LogSimpleErr(s, "kept too", nil)
+	LogAudit(s, "me", "act", "you", "audit is never dropped", INFO)
+	if len(*logs) != 2 || len(*audits) != 1 {
+		t.Errorf("TestLogLevel: expected 2 logs and 1 audit, got %v and %v", *logs, *audits)
+	}
+
+	if err := SetupLogging(Config{LogLevel: "loud"}); err == nil {
+		t.Error("TestLogLevel: accepted an unknown level")
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the histogram buckets used when none are given.  They
+// are in seconds, and suited to the durations of HTTP calls.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// metric is anything that can write itself out in the Prometheus text
+// exposition format
+type metric interface {
+	writeTo(w io.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   []metric
+
+	httpDuration = NewHistogram("pzsvc_http_request_duration_seconds",
+		"Duration of outgoing HTTP calls made through pzsvc, by endpoint.", DefaultBuckets, "method", "endpoint")
+)
+
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics = append(metrics, m)
+}
+
+// metricVec holds the per-label-set values for a single named metric
+type metricVec struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	keys       []string            // label-set keys, in the order first seen
+	labels     map[string][]string // label values for each key
+}
+
+func newMetricVec(name, help string, labelNames []string) metricVec {
+	return metricVec{name: name, help: help, labelNames: labelNames, labels: map[string][]string{}}
+}
+
+// key returns the key for the given label values, registering it if new.
+// Must be called with mu held.
+func (mv *metricVec) key(labelValues []string) string {
+	if len(labelValues) != len(mv.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", mv.name, len(mv.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := mv.labels[key]; !ok {
+		mv.keys = append(mv.keys, key)
+		mv.labels[key] = append([]string{}, labelValues...)
+	}
+	return key
+}
+
+func (mv *metricVec) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", mv.name, mv.help, mv.name, metricType)
+}
+
+// labelString formats the given label values, plus any extra name/value
+// pairs, as a Prometheus label set
+func (mv *metricVec) labelString(labelValues []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range mv.labelNames {
+		pairs = append(pairs, name+`="`+escapeLabel(labelValues[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Counter is a metric that only ever goes up
+type Counter struct {
+	metricVec
+	values map[string]float64
+}
+
+// NewCounter creates and registers a Counter with the given label names
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{metricVec: newMetricVec(name, help, labelNames), values: map[string]float64{}}
+	registerMetric(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the given amount to the counter for the given label values
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)] += delta
+}
+
+func (c *Counter) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, key := range c.keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(c.labels[key]), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge is a metric that may go up and down
+type Gauge struct {
+	metricVec
+	values map[string]float64
+}
+
+// NewGauge creates and registers a Gauge with the given label names
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{metricVec: newMetricVec(name, help, labelNames), values: map[string]float64{}}
+	registerMetric(g)
+	return g
+}
+
+// Set sets the gauge for the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] = value
+}
+
+func (g *Gauge) writeTo(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w, "gauge")
+	for _, key := range g.keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(g.labels[key]), formatFloat(g.values[key]))
+	}
+}
+
+// Histogram is a metric that counts observations into buckets
+type Histogram struct {
+	metricVec
+	buckets []float64
+	counts  map[string][]uint64 // cumulative count for each bucket
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogram creates and registers a Histogram with the given bucket upper
+// bounds and label names
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	h := &Histogram{
+		metricVec: newMetricVec(name, help, labelNames),
+		buckets:   sorted,
+		counts:    map[string][]uint64{},
+		sums:      map[string]float64{},
+		totals:    map[string]uint64{},
+	}
+	registerMetric(h)
+	return h
+}
+
+// Observe records a single value for the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	if h.counts[key] == nil {
+		h.counts[key] = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[key][i]++
+		}
+	}
+	h.sums[key] += value
+	h.totals[key]++
+}
+
+// ObserveSince records the time elapsed since the given start, in seconds
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, key := range h.keys {
+		labelValues := h.labels[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(labelValues, "le", formatFloat(bound)), h.counts[key][i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(labelValues, "le", "+Inf"), h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(labelValues), formatFloat(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(labelValues), h.totals[key])
+	}
+}
+
+// WriteMetrics writes every registered metric to the given writer, in the
+// Prometheus text exposition format
+func WriteMetrics(w io.Writer) {
+	metricsMu.Lock()
+	all := append([]metric{}, metrics...)
+	metricsMu.Unlock()
+	for _, m := range all {
+		m.writeTo(w)
+	}
+}
+
+// MetricsHandler serves the registered metrics to a Prometheus scraper
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w)
+}
+
+// PushMetrics sends the registered metrics to a Prometheus pushgateway at the
+// given address, in the group named by the given job and any further grouping
+// labels, given as name, value pairs.  The push replaces everything in that
+// group, so processes that push at the same time must use different groups,
+// or they overwrite each other.
+func PushMetrics(gatewayAddr, job string, labels ...string) *PzCustomError {
+	var body bytes.Buffer
+	WriteMetrics(&body)
+	targURL := strings.TrimRight(gatewayAddr, "/") + "/metrics/job/" + url.PathEscape(job)
+	for i := 0; i+1 < len(labels); i += 2 {
+		targURL += "/" + url.PathEscape(labels[i]) + "/" + url.PathEscape(labels[i+1])
+	}
+	req, err := http.NewRequest("PUT", targURL, &body)
+	if err != nil {
+		return &PzCustomError{LogMsg: "Could not push metrics: " + err.Error(), url: targURL}
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := HTTPClient().Do(req)
+	if err != nil {
+		return &PzCustomError{LogMsg: "Could not push metrics: " + err.Error(), url: targURL}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &PzCustomError{LogMsg: "Received " + http.StatusText(resp.StatusCode) + " pushing metrics", url: targURL, httpStatus: resp.StatusCode}
+	}
+	return nil
+}
+
+// metricEndpoint reduces a URL to a label suitable for grouping calls by
+// endpoint.  Query strings are dropped, and any path segment containing a
+// digit is taken to be an ID and replaced with a placeholder.
+func metricEndpoint(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "unknown"
+	}
+	segments := strings.Split(parsed.Path, "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, "0123456789") {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,90 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	counter := NewCounter("test_counter_total", "A test counter.", "svc")
+	gauge := NewGauge("test_gauge", "A test gauge.")
+	hist := NewHistogram("test_hist_seconds", "A test histogram.", []float64{1, 0.5}, "endpoint")
+
+	counter.Inc("a")
+	counter.Add(2, "a")
+	counter.Inc(`b"q`)
+	gauge.Set(7)
+	hist.Observe(0.25, "/job/{id}")
+	hist.Observe(0.75, "/job/{id}")
+	hist.Observe(3, "/job/{id}")
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	out := buf.String()
+	expected := []string{
+		"# TYPE test_counter_total counter\n",
+		`test_counter_total{svc="a"} 3` + "\n",
+		`test_counter_total{svc="b\"q"} 1` + "\n",
+		"test_gauge 7\n",
+		`test_hist_seconds_bucket{endpoint="/job/{id}",le="0.5"} 1` + "\n",
+		`test_hist_seconds_bucket{endpoint="/job/{id}",le="1"} 2` + "\n",
+		`test_hist_seconds_bucket{endpoint="/job/{id}",le="+Inf"} 3` + "\n",
+		`test_hist_seconds_sum{endpoint="/job/{id}"} 4` + "\n",
+		`test_hist_seconds_count{endpoint="/job/{id}"} 3` + "\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Error(`TestMetrics: output missing line: ` + line)
+		}
+	}
+}
+
+func TestMetricEndpoint(t *testing.T) {
+	inputs := map[string]string{
+		"http://pz.net/service/a1b2-c3/task/77f3": "/service/{id}/task/{id}",
+		"http://pz.net/service?keyword=x&n=3":     "/service",
+		"http://pz.net/data/file":                 "/data/file",
+	}
+	for in, expected := range inputs {
+		if out := metricEndpoint(in); out != expected {
+			t.Error(`TestMetricEndpoint: "` + in + `" gave "` + out + `", expected "` + expected + `".`)
+		}
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	var method, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.EscapedPath()
+	}))
+	defer server.Close()
+	SetHTTPClient(&http.Client{})
+	defer SetHTTPClient(nil)
+
+	if err := PushMetrics(server.URL+"/", "pzsvc-worker", "service", "svc/1", "instance", "job1"); err != nil {
+		t.Fatal(`TestPushMetrics: ` + err.Error())
+	}
+	if method != "PUT" {
+		t.Error(`TestPushMetrics: pushed with ` + method + `, which does not replace the group.`)
+	}
+	if path != "/metrics/job/pzsvc-worker/service/svc%2F1/instance/job1" {
+		t.Error(`TestPushMetrics: pushed to ` + path)
+	}
+}
@@ -14,7 +14,12 @@
 
 package pzsvc
 
-import "time"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 /*****************************/
 /*** pzsvc Session Objects ***/
@@ -35,6 +40,20 @@ type Session struct {
 	SubFold    string // The name of the subfolder this session has been assigned (if any)
 	LogRootDir string // The root directory that has all associated go packages that use pzsvc logging.  Helps keep file locs short.
 	LogAudit   bool   // True to log all auditable events
+	Span       *Span  // The trace span, if any, that calls made for this session belong to
+	Class      string // The classification stamped on data ingested for this session.  UNCLASSIFIED if blank.
+
+	LogFields map[string]string // Structured fields added to every log entry for this session.  Set through WithField.
+	Context   context.Context   // If not nil, cancelling it aborts this session's uploads and its waits for Pz jobs
+}
+
+// done returns a channel that is closed when the session's Context is
+// cancelled, or nil if it has none
+func (s Session) done() <-chan struct{} {
+	if s.Context == nil {
+		return nil
+	}
+	return s.Context.Done()
 }
 
 /***************************/
@@ -108,6 +127,13 @@ type DeplStrct struct {
 	Port            string `json:"port,omitempty"`
 }
 
+// DeployReq is the request object used to deploy data to GeoServer.
+type DeployReq struct {
+	Type           string `json:"type"` // "access"
+	DataID         string `json:"dataId"`
+	DeploymentType string `json:"deploymentType"` // "geoserver"
+}
+
 // DataResult is a hack to handle the fact that the backend we're addressing
 // uses a lot of inheritence here.  Any one of five different classes could
 // fill the slots set aside for DataResult objects in a job response.  Impl01
@@ -177,18 +203,56 @@ type Service struct {
 
 // InpStruct is the format that pzsvc-exec demarshals input data into
 type InpStruct struct {
-	Command    string   `json:"cmd,omitempty"`
-	UserID     string   `json:"userID,omitempty"`       // string: unique ID of initiating user
-	InPzFiles  []string `json:"inPzFiles,omitempty"`    // slice: Pz dataIds
-	InExtFiles []string `json:"inExtFiles,omitempty"`   // slice: external URL
-	InPzNames  []string `json:"inPzNames,omitempty"`    // slice: name for the InPzFile of the same index
-	InExtNames []string `json:"inExtNames,omitempty"`   // slice: name for the InExtFile of the same index
-	OutTiffs   []string `json:"outTiffs,omitempty"`     // slice: filenames of GeoTIFFs to be ingested
-	OutTxts    []string `json:"outTxts,omitempty"`      // slice: filenames of text files to be ingested
-	OutGeoJs   []string `json:"outGeoJson,omitempty"`   // slice: filenames of GeoJSON files to be ingested
-	ExtAuth    string   `json:"inExtAuthKey,omitempty"` // string: auth key for accessing external files
-	PzAuth     string   `json:"pzAuthKey,omitempty"`    // string: auth key for accessing Piazza
-	PzAddr     string   `json:"pzAddr,omitempty"`       // string: URL for the targeted Pz instance
+	Command    string            `json:"cmd,omitempty"`
+	UserID     string            `json:"userID,omitempty"`       // string: unique ID of initiating user
+	InPzFiles  []string          `json:"inPzFiles,omitempty"`    // slice: Pz dataIds
+	InExtFiles []string          `json:"inExtFiles,omitempty"`   // slice: external URL
+	InPzNames  []string          `json:"inPzNames,omitempty"`    // slice: name for the InPzFile of the same index
+	InExtNames []string          `json:"inExtNames,omitempty"`   // slice: name for the InExtFile of the same index
+	OutTiffs   []string          `json:"outTiffs,omitempty"`     // slice: filenames of GeoTIFFs to be ingested
+	OutTxts    []string          `json:"outTxts,omitempty"`      // slice: filenames of text files to be ingested
+	OutGeoJs   []string          `json:"outGeoJson,omitempty"`   // slice: filenames of GeoJSON files to be ingested
+	OutSpecs   []string          `json:"outSpecs,omitempty"`     // slice: glob patterns or directories of further files to be ingested
+	Outputs    []OutFile         `json:"outputs,omitempty"`      // slice: further outputs, each of which may ask to be deployed
+	ExtAuth    string            `json:"inExtAuthKey,omitempty"` // string: auth key for accessing external files
+	PzAuth     string            `json:"pzAuthKey,omitempty"`    // string: auth key for accessing Piazza
+	PzAddr     string            `json:"pzAddr,omitempty"`       // string: URL for the targeted Pz instance
+	Priority   int               `json:"priority,omitempty"`     // int: relative priority of the job.  Higher goes first.
+	Attributes map[string]string `json:"attributes,omitempty"`   // map: attributes to record with the job's outputs
+	Class      string            `json:"class,omitempty"`        // string: classification of the job's outputs.  No lower than the service's.
+}
+
+// OutFile names one output of a job.  In JSON, it is either the bare name,
+// or an object giving the name and whether to deploy the output to GeoServer
+// once it has been ingested.
+type OutFile struct {
+	Name   string `json:"name"`
+	Deploy bool   `json:"deploy,omitempty"`
+}
+
+// UnmarshalJSON accepts either form of OutFile
+func (o *OutFile) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*o = OutFile{Name: name}
+		return nil
+	}
+	type outFileObject OutFile
+	var obj outFileObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("output must be a file name or an object with a name: %v", err)
+	}
+	*o = OutFile(obj)
+	return nil
+}
+
+// MarshalJSON writes the bare name of an OutFile that is not to be deployed
+func (o OutFile) MarshalJSON() ([]byte, error) {
+	if !o.Deploy {
+		return json.Marshal(o.Name)
+	}
+	type outFileObject OutFile
+	return json.Marshal(outFileObject(o))
 }
 
 // IngestReq is the base object used to ingest a file to Piazza.
@@ -363,3 +427,18 @@ var PiazzaStatusError PiazzaStatus = "Error"
 
 // PiazzaStatusFail is a Piazza job status corresponding to failure prior to running the job
 var PiazzaStatusFail PiazzaStatus = "Fail"
+
+// PiazzaStatusRunning is a Piazza job status corresponding to a job that has been picked up but not yet finished
+var PiazzaStatusRunning PiazzaStatus = "Running"
+
+// PiazzaStatusCancelled is a Piazza job status corresponding to a job cancelled by the user
+var PiazzaStatusCancelled PiazzaStatus = "Cancelled"
+
+// IsFinal returns true if the status is one from which a job will not progress any further
+func (ps PiazzaStatus) IsFinal() bool {
+	switch ps {
+	case PiazzaStatusSuccess, PiazzaStatusError, PiazzaStatusFail, PiazzaStatusCancelled:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RedactedText is what secrets are replaced with in logs
+const RedactedText = "*****"
+
+var secretKeyNames = `pzAuthKey|inExtAuthKey|PiazzaAPIKey|password|passwd|apiKey|api_key|secret|token|accessToken|access_token`
+
+var (
+	// "key":"value" pairs in JSON
+	secretJSONRegexp = regexp.MustCompile(`(?i)("(?:` + secretKeyNames + `)"\s*:\s*")((?:[^"\\]|\\.)*)`)
+	// the same, within JSON that has itself been escaped into a string
+	secretEscJSONRegexp = regexp.MustCompile(`(?i)(\\"(?:` + secretKeyNames + `)\\"\s*:\s*\\")((?:[^"\\]|\\[^"])*)`)
+	// key=value pairs, as in query strings and logfmt
+	secretPairRegexp = regexp.MustCompile(`(?i)\b((?:` + secretKeyNames + `)=)([^&\s"]+)`)
+	// Authorization header values.  Credentials are taken to contain at
+	// least one digit or base64 symbol, to pass over ordinary words.
+	secretAuthRegexp = regexp.MustCompile(`(?i)\b(Basic|Bearer)\s+[A-Za-z0-9+/=._~-]*[0-9+/=][A-Za-z0-9+/=._~-]*`)
+)
+
+var (
+	redactMu       sync.RWMutex
+	redactPatterns []*regexp.Regexp
+	redactSecrets  []string
+)
+
+// SetupRedaction adds the regular expressions given in the RedactPatterns
+// setting of the config to those scrubbed from logs.  Anything matching them
+// is replaced in full.
+func SetupRedaction(config Config) error {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range config.RedactPatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, compiled)
+	}
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	redactPatterns = append(redactPatterns, patterns...)
+	return nil
+}
+
+// RegisterSecret marks the given value as secret, so that it is scrubbed from
+// logs wherever it appears.  Values shorter than four characters are ignored,
+// as too likely to match innocent text.
+func RegisterSecret(secret string) {
+	if len(secret) < 4 {
+		return
+	}
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	redactSecrets = append(redactSecrets, secret)
+}
+
+// Redact scrubs secrets from the given text: the values of known secret JSON
+// fields and key=value pairs, Authorization header values, any registered
+// secret values, and anything matching the configured RedactPatterns.  It is
+// applied to everything that pzsvc logs, and to the errors it returns.
+func Redact(text string) string {
+	text = secretJSONRegexp.ReplaceAllString(text, "${1}"+RedactedText)
+	text = secretEscJSONRegexp.ReplaceAllString(text, "${1}"+RedactedText)
+	text = secretPairRegexp.ReplaceAllString(text, "${1}"+RedactedText)
+	text = secretAuthRegexp.ReplaceAllString(text, "${1} "+RedactedText)
+
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	for _, secret := range redactSecrets {
+		text = strings.Replace(text, secret, RedactedText, -1)
+	}
+	for _, pattern := range redactPatterns {
+		text = pattern.ReplaceAllString(text, RedactedText)
+	}
+	return text
+}
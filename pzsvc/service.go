@@ -27,7 +27,7 @@ func FindMySvc(s Session, svcName string) (string, LoggedError) {
 	var profile UserProfileResp
 	query := s.PzAddr + "/profile"
 	LogAudit(s, s.UserID, "http request - looking for profile "+svcName, query, "", INFO)
-	byts, err := RequestKnownJSON("GET", "", query, s.PzAuth, &profile)
+	byts, err := requestKnownJSON(s.Span, "GET", "", query, s.PzAuth, &profile)
 	LogAudit(s, query, "http response to profile request", s.UserID, string(byts), INFO)
 	if err != nil {
 		return "", err.Log(s, "Error when acquiring profile")
@@ -36,7 +36,7 @@ func FindMySvc(s Session, svcName string) (string, LoggedError) {
 	var respObj SvcList
 	query = s.PzAddr + "/service?per_page=1000&keyword=" + url.QueryEscape(svcName) + "&createdBy=" + profile.Data.UserProfile.UserName
 	LogAudit(s, s.UserID, "http request - looking for service "+svcName, query, "", INFO)
-	byts, err = RequestKnownJSON("GET", "", query, s.PzAuth, &respObj)
+	byts, err = requestKnownJSON(s.Span, "GET", "", query, s.PzAuth, &respObj)
 	LogAudit(s, query, "http response to service listing request", s.UserID, string(byts), INFO)
 	if err != nil {
 		return "", err.Log(s, "Error when finding Pz Service")
@@ -71,13 +71,13 @@ func ManageRegistration(s Session, svcObj Service) LoggedError {
 		LogInfo(s, "Registering Service")
 		targURL := s.PzAddr + "/service"
 		LogAudit(s, s.AppName, "Registering Service request", targURL, string(svcJSON), INFO)
-		resp, pzErr = SubmitSinglePart("POST", string(svcJSON), targURL, s.PzAuth)
+		resp, pzErr = submitSinglePart(s.Span, "POST", string(svcJSON), targURL, s.PzAuth)
 		LogAuditResponse(s, targURL, "Registering Service Response", s.AppName, resp, INFO)
 	} else {
 		LogInfo(s, "Updating Service Registration")
 		targURL := s.PzAddr + "/service/" + svcID
 		LogAudit(s, s.AppName, "Updating Service request", targURL, string(svcJSON), INFO)
-		resp, pzErr = SubmitSinglePart("PUT", string(svcJSON), s.PzAddr+"/service/"+svcID, s.PzAuth)
+		resp, pzErr = submitSinglePart(s.Span, "PUT", string(svcJSON), s.PzAddr+"/service/"+svcID, s.PzAuth)
 		LogAuditResponse(s, targURL, "Updating Service Response", s.AppName, resp, INFO)
 	}
 	if pzErr != nil {
@@ -0,0 +1,491 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// defaultGeoJSONEPSG is the coordinate reference system of GeoJSON that does
+// not name one: WGS 84
+const defaultGeoJSONEPSG = 4326
+
+var epsgRegexp = regexp.MustCompile(`(?i)EPSG:+(?:[0-9.]*:)?([0-9]+)$`)
+
+// crs84Regexp matches the names of OGC CRS84, which is WGS 84 with longitude
+// before latitude, as GeoJSON has it.  It is the same datum as EPSG:4326.
+var crs84Regexp = regexp.MustCompile(`(?i)(?:^|:)CRS:?84$`)
+
+// spatialBounds accumulates the extent of a set of positions
+type spatialBounds struct {
+	meta      SpatMeta
+	positions int
+	hasZ      bool
+}
+
+func (b *spatialBounds) add(position []float64) {
+	if len(position) < 2 {
+		return
+	}
+	if b.positions == 0 {
+		b.meta.MinX, b.meta.MaxX = position[0], position[0]
+		b.meta.MinY, b.meta.MaxY = position[1], position[1]
+	}
+	b.meta.MinX = math.Min(b.meta.MinX, position[0])
+	b.meta.MaxX = math.Max(b.meta.MaxX, position[0])
+	b.meta.MinY = math.Min(b.meta.MinY, position[1])
+	b.meta.MaxY = math.Max(b.meta.MaxY, position[1])
+	if len(position) > 2 {
+		if !b.hasZ {
+			b.meta.MinZ, b.meta.MaxZ = position[2], position[2]
+			b.hasZ = true
+		}
+		b.meta.MinZ = math.Min(b.meta.MinZ, position[2])
+		b.meta.MaxZ = math.Max(b.meta.MaxZ, position[2])
+	}
+	b.positions++
+}
+
+// spatialMetadata works out the spatial metadata of data of the given
+// Piazza type, if that type has any that can be read.  Returns nil for other
+// types.
+func spatialMetadata(fType string, data *io.SectionReader) (*SpatMeta, error) {
+	switch fType {
+	case "geojson":
+		return geoJSONSpatMeta(io.NewSectionReader(data, 0, data.Size()))
+	case "raster":
+		return GeoTIFFSpatMeta(data)
+	}
+	return nil, nil
+}
+
+/***************/
+/*** GeoJSON ***/
+/***************/
+
+type geoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates"`
+	Geometries  []geoJSONGeometry `json:"geometries"`
+}
+
+type geoJSONCRS struct {
+	Properties struct {
+		Name string `json:"name"`
+	} `json:"properties"`
+}
+
+// geoJSONSpatMeta reads the bounding box, feature count and coordinate
+// reference system of a GeoJSON document.  Features are decoded one at a
+// time, so that large documents need not be held in memory.
+func geoJSONSpatMeta(r io.Reader) (*SpatMeta, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	bounds := spatialBounds{}
+	features := 0
+	var geometry geoJSONGeometry
+	var crs *geoJSONCRS
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "features":
+			if err := expectDelim(dec, '['); err != nil {
+				return nil, err
+			}
+			for dec.More() {
+				var feature struct {
+					Geometry *geoJSONGeometry `json:"geometry"`
+				}
+				if err := dec.Decode(&feature); err != nil {
+					return nil, err
+				}
+				if feature.Geometry != nil {
+					if err := addGeometry(&bounds, *feature.Geometry); err != nil {
+						return nil, err
+					}
+				}
+				features++
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+		case "geometry":
+			var featureGeometry *geoJSONGeometry
+			if err := dec.Decode(&featureGeometry); err != nil {
+				return nil, err
+			}
+			if featureGeometry != nil {
+				if err := addGeometry(&bounds, *featureGeometry); err != nil {
+					return nil, err
+				}
+			}
+			features = 1
+		case "coordinates":
+			if err := dec.Decode(&geometry.Coordinates); err != nil {
+				return nil, err
+			}
+		case "geometries":
+			if err := dec.Decode(&geometry.Geometries); err != nil {
+				return nil, err
+			}
+		case "crs":
+			if err := dec.Decode(&crs); err != nil {
+				return nil, err
+			}
+		default:
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// A bare geometry has its coordinates at the top level
+	if err := addGeometry(&bounds, geometry); err != nil {
+		return nil, err
+	}
+
+	meta := bounds.meta
+	meta.NumFeatures = features
+	meta.EpsgCode = defaultGeoJSONEPSG
+	meta.CoordRefSystem = "EPSG:" + strconv.Itoa(defaultGeoJSONEPSG)
+	if crs != nil && crs.Properties.Name != "" {
+		meta.CoordRefSystem = crs.Properties.Name
+		meta.EpsgCode = 0
+		if match := epsgRegexp.FindStringSubmatch(crs.Properties.Name); match != nil {
+			meta.EpsgCode, _ = strconv.Atoi(match[1])
+		} else if crs84Regexp.MatchString(crs.Properties.Name) {
+			meta.EpsgCode = defaultGeoJSONEPSG
+		}
+	}
+	return &meta, nil
+}
+
+// addGeometry adds the positions of a geometry to the bounds
+func addGeometry(bounds *spatialBounds, geometry geoJSONGeometry) error {
+	for _, child := range geometry.Geometries {
+		if err := addGeometry(bounds, child); err != nil {
+			return err
+		}
+	}
+	if len(geometry.Coordinates) == 0 || string(geometry.Coordinates) == "null" {
+		return nil
+	}
+	var coordinates interface{}
+	if err := json.Unmarshal(geometry.Coordinates, &coordinates); err != nil {
+		return err
+	}
+	return addCoordinates(bounds, coordinates)
+}
+
+// addCoordinates adds a position, or nested arrays of positions, to the
+// bounds
+func addCoordinates(bounds *spatialBounds, coordinates interface{}) error {
+	array, ok := coordinates.([]interface{})
+	if !ok {
+		return errors.New("GeoJSON coordinates are not an array")
+	}
+	if len(array) == 0 {
+		return nil
+	}
+	if _, isNumber := array[0].(float64); !isNumber {
+		for _, child := range array {
+			if err := addCoordinates(bounds, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	position := make([]float64, len(array))
+	for i, value := range array {
+		number, ok := value.(float64)
+		if !ok {
+			return errors.New("GeoJSON position holds a value that is not a number")
+		}
+		position[i] = number
+	}
+	bounds.add(position)
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v in GeoJSON, found %v", delim, token)
+	}
+	return nil
+}
+
+/***************/
+/*** GeoTIFF ***/
+/***************/
+
+// TIFF and GeoTIFF tags and GeoKeys read for spatial metadata
+const (
+	tiffTagImageWidth       = 256
+	tiffTagImageLength      = 257
+	tiffTagModelPixelScale  = 33550
+	tiffTagModelTiepoint    = 33922
+	tiffTagModelTransform   = 34264
+	tiffTagGeoKeyDirectory  = 34735
+	geoKeyGeographicType    = 2048
+	geoKeyProjectedCSType   = 3072
+	geoKeyUserDefined       = 32767
+	tiffMaxValuesPerTag     = 1 << 16
+	tiffTypeShort           = 3
+	tiffTypeLong            = 4
+	tiffTypeDouble          = 12
+	tiffTypeLong8           = 16
+	classicTIFFEntrySize    = 12
+	bigTIFFEntrySize        = 20
+	classicTIFFInlineValues = 4
+	bigTIFFInlineValues     = 8
+)
+
+// tiffReader reads the tags of the first image in a TIFF or BigTIFF file
+type tiffReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+	big   bool
+	tags  map[uint16]tiffEntry
+}
+
+type tiffEntry struct {
+	typ    uint16
+	count  uint64
+	offset int64 // where the values are, whether inline or not
+}
+
+// GeoTIFFSpatMeta reads the bounding box and EPSG code of a GeoTIFF from its
+// tags.  The bounding box is found from the tiepoint and pixel scale, or the
+// model transformation, applied to the corners of the image.  Returns an
+// error for anything but a well-formed, georeferenced TIFF.
+func GeoTIFFSpatMeta(r io.ReaderAt) (*SpatMeta, error) {
+	tiff, err := readTIFFTags(r)
+	if err != nil {
+		return nil, err
+	}
+
+	width, err := tiff.uints(tiffTagImageWidth)
+	if err != nil || len(width) != 1 {
+		return nil, errors.New("TIFF has no image width")
+	}
+	height, err := tiff.uints(tiffTagImageLength)
+	if err != nil || len(height) != 1 {
+		return nil, errors.New("TIFF has no image length")
+	}
+
+	// Map raster space to model space, either through the affine
+	// transformation or through one tiepoint and the pixel scale
+	var transform func(i, j float64) (float64, float64)
+	if matrix, err := tiff.doubles(tiffTagModelTransform); err == nil && len(matrix) >= 8 {
+		transform = func(i, j float64) (float64, float64) {
+			return matrix[0]*i + matrix[1]*j + matrix[3], matrix[4]*i + matrix[5]*j + matrix[7]
+		}
+	} else {
+		tiepoint, tpErr := tiff.doubles(tiffTagModelTiepoint)
+		scale, scErr := tiff.doubles(tiffTagModelPixelScale)
+		if tpErr != nil || scErr != nil || len(tiepoint) < 6 || len(scale) < 2 {
+			return nil, errors.New("TIFF has no georeferencing tags")
+		}
+		transform = func(i, j float64) (float64, float64) {
+			return tiepoint[3] + (i-tiepoint[0])*scale[0], tiepoint[4] - (j-tiepoint[1])*scale[1]
+		}
+	}
+
+	bounds := spatialBounds{}
+	for _, corner := range [][2]float64{{0, 0}, {float64(width[0]), 0}, {0, float64(height[0])}, {float64(width[0]), float64(height[0])}} {
+		x, y := transform(corner[0], corner[1])
+		bounds.add([]float64{x, y})
+	}
+	meta := bounds.meta
+
+	if keys, err := tiff.uints(tiffTagGeoKeyDirectory); err == nil && len(keys) >= 4 {
+		geoKeys := map[uint64]uint64{}
+		for k := 4; k+3 < len(keys) && k < 4+4*int(keys[3]); k += 4 {
+			// Only keys held inline, with no tag location, are simple codes
+			if keys[k+1] == 0 {
+				geoKeys[keys[k]] = keys[k+3]
+			}
+		}
+		for _, key := range []uint64{geoKeyProjectedCSType, geoKeyGeographicType} {
+			if code, ok := geoKeys[key]; ok && code != 0 && code != geoKeyUserDefined {
+				meta.EpsgCode = int(code)
+				meta.CoordRefSystem = "EPSG:" + strconv.Itoa(meta.EpsgCode)
+				break
+			}
+		}
+	}
+	return &meta, nil
+}
+
+// readTIFFTags reads the header of a TIFF or BigTIFF file and the entries of
+// its first image file directory
+func readTIFFTags(r io.ReaderAt) (*tiffReader, error) {
+	header := make([]byte, 16)
+	if _, err := r.ReadAt(header[:8], 0); err != nil {
+		return nil, errors.New("file too short to be a TIFF")
+	}
+	tiff := &tiffReader{r: r, tags: map[uint16]tiffEntry{}}
+	switch string(header[:2]) {
+	case "II":
+		tiff.order = binary.LittleEndian
+	case "MM":
+		tiff.order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF file")
+	}
+
+	var ifdOffset int64
+	switch tiff.order.Uint16(header[2:4]) {
+	case 42:
+		ifdOffset = int64(tiff.order.Uint32(header[4:8]))
+	case 43:
+		tiff.big = true
+		if _, err := r.ReadAt(header, 0); err != nil {
+			return nil, errors.New("file too short to be a BigTIFF")
+		}
+		ifdOffset = int64(tiff.order.Uint64(header[8:16]))
+	default:
+		return nil, errors.New("not a TIFF file")
+	}
+
+	countSize, entrySize, inline := 2, classicTIFFEntrySize, int64(classicTIFFInlineValues)
+	if tiff.big {
+		countSize, entrySize, inline = 8, bigTIFFEntrySize, bigTIFFInlineValues
+	}
+	countBytes := make([]byte, countSize)
+	if _, err := r.ReadAt(countBytes, ifdOffset); err != nil {
+		return nil, errors.New("TIFF image file directory is missing")
+	}
+	var entries uint64
+	if tiff.big {
+		entries = tiff.order.Uint64(countBytes)
+	} else {
+		entries = uint64(tiff.order.Uint16(countBytes))
+	}
+	if entries > tiffMaxValuesPerTag {
+		return nil, errors.New("TIFF image file directory is implausibly large")
+	}
+
+	entry := make([]byte, entrySize)
+	for n := int64(0); n < int64(entries); n++ {
+		entryOffset := ifdOffset + int64(countSize) + n*int64(entrySize)
+		if _, err := r.ReadAt(entry, entryOffset); err != nil {
+			return nil, errors.New("TIFF image file directory is truncated")
+		}
+		tag := tiff.order.Uint16(entry[0:2])
+		e := tiffEntry{typ: tiff.order.Uint16(entry[2:4])}
+		valueField := entryOffset + 8
+		if tiff.big {
+			e.count = tiff.order.Uint64(entry[4:12])
+			valueField = entryOffset + 12
+		} else {
+			e.count = uint64(tiff.order.Uint32(entry[4:8]))
+		}
+		size := tiffTypeSize(e.typ)
+		if size == 0 || e.count > tiffMaxValuesPerTag {
+			continue
+		}
+		e.offset = valueField
+		if int64(e.count)*size > inline {
+			if tiff.big {
+				e.offset = int64(tiff.order.Uint64(entry[12:20]))
+			} else {
+				e.offset = int64(tiff.order.Uint32(entry[8:12]))
+			}
+		}
+		tiff.tags[tag] = e
+	}
+	return tiff, nil
+}
+
+func tiffTypeSize(typ uint16) int64 {
+	switch typ {
+	case tiffTypeShort:
+		return 2
+	case tiffTypeLong:
+		return 4
+	case tiffTypeDouble, tiffTypeLong8:
+		return 8
+	}
+	return 0
+}
+
+// values reads the raw values of a tag
+func (t *tiffReader) values(tag uint16) (tiffEntry, []byte, error) {
+	e, ok := t.tags[tag]
+	if !ok {
+		return e, nil, fmt.Errorf("TIFF has no tag %d", tag)
+	}
+	data := make([]byte, int64(e.count)*tiffTypeSize(e.typ))
+	if _, err := t.r.ReadAt(data, e.offset); err != nil {
+		return e, nil, fmt.Errorf("TIFF tag %d is truncated", tag)
+	}
+	return e, data, nil
+}
+
+// uints reads the values of an integer tag
+func (t *tiffReader) uints(tag uint16) ([]uint64, error) {
+	e, data, err := t.values(tag)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]uint64, e.count)
+	for i := range out {
+		switch e.typ {
+		case tiffTypeShort:
+			out[i] = uint64(t.order.Uint16(data[i*2:]))
+		case tiffTypeLong:
+			out[i] = uint64(t.order.Uint32(data[i*4:]))
+		case tiffTypeLong8:
+			out[i] = t.order.Uint64(data[i*8:])
+		default:
+			return nil, fmt.Errorf("TIFF tag %d is not an integer", tag)
+		}
+	}
+	return out, nil
+}
+
+// doubles reads the values of a DOUBLE tag
+func (t *tiffReader) doubles(tag uint16) ([]float64, error) {
+	e, data, err := t.values(tag)
+	if err != nil {
+		return nil, err
+	}
+	if e.typ != tiffTypeDouble {
+		return nil, fmt.Errorf("TIFF tag %d is not a double", tag)
+	}
+	out := make([]float64, e.count)
+	for i := range out {
+		out[i] = math.Float64frombits(t.order.Uint64(data[i*8:]))
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestGeoJSONSpatMeta(t *testing.T) {
+	inputs := map[string]SpatMeta{
+		`{"type": "FeatureCollection", "features": [
+			{"type": "Feature", "properties": {"a": [9, 9]}, "geometry": {"type": "Point", "coordinates": [1, 2]}},
+			{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[-3, 4, 10], [5, -6, 20], [-3, 4, 10]]]}},
+			{"type": "Feature", "geometry": null}]}`: {MinX: -3, MinY: -6, MinZ: 10, MaxX: 5, MaxY: 4, MaxZ: 20, NumFeatures: 3, EpsgCode: 4326, CoordRefSystem: "EPSG:4326"},
+		`{"crs": {"type": "name", "properties": {"name": "urn:ogc:def:crs:EPSG::32617"}}, "type": "Feature",
+			"geometry": {"type": "GeometryCollection", "geometries": [{"type": "Point", "coordinates": [500000, 4000000]}]}}`: {MinX: 500000, MinY: 4000000, MaxX: 500000, MaxY: 4000000, NumFeatures: 1, EpsgCode: 32617, CoordRefSystem: "urn:ogc:def:crs:EPSG::32617"},
+		`{"type": "LineString", "coordinates": [[1, 1], [2, 3]]}`:                                                                    {MinX: 1, MinY: 1, MaxX: 2, MaxY: 3, EpsgCode: 4326, CoordRefSystem: "EPSG:4326"},
+		`{"crs": {"type": "name", "properties": {"name": "urn:ogc:def:crs:OGC:1.3:CRS84"}}, "type": "Point", "coordinates": [1, 2]}`: {MinX: 1, MinY: 2, MaxX: 1, MaxY: 2, EpsgCode: 4326, CoordRefSystem: "urn:ogc:def:crs:OGC:1.3:CRS84"},
+		`{"crs": {"type": "name", "properties": {"name": "OGC:CRS84"}}, "type": "Point", "coordinates": [1, 2]}`:                     {MinX: 1, MinY: 2, MaxX: 1, MaxY: 2, EpsgCode: 4326, CoordRefSystem: "OGC:CRS84"},
+		`{"crs": {"type": "name", "properties": {"name": "urn:ogc:def:crs:OGC::CRS83"}}, "type": "Point", "coordinates": [1, 2]}`:    {MinX: 1, MinY: 2, MaxX: 1, MaxY: 2, EpsgCode: 0, CoordRefSystem: "urn:ogc:def:crs:OGC::CRS83"},
+	}
+	for input, expected := range inputs {
+		actual, err := geoJSONSpatMeta(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("TestGeoJSONSpatMeta: error on %s: %v", input, err)
+		} else if *actual != expected {
+			t.Errorf("TestGeoJSONSpatMeta: for %s, expected %+v, got %+v", input, expected, *actual)
+		}
+	}
+
+	for _, input := range []string{`[1, 2]`, `{"type": "Point", "coordinates": ["a", "b"]}`, `{"features": [`} {
+		if _, err := geoJSONSpatMeta(strings.NewReader(input)); err == nil {
+			t.Errorf("TestGeoJSONSpatMeta: accepted %s", input)
+		}
+	}
+}
+
+// testGeoTIFF builds a little-endian GeoTIFF of the given size, with a
+// tiepoint, pixel scale and projected CS GeoKey
+func testGeoTIFF(width, height uint16, tiepoint, scale []float64, epsg uint16) []byte {
+	type entry struct {
+		tag, typ uint16
+		values   interface{}
+	}
+	entries := []entry{
+		{tiffTagImageWidth, tiffTypeShort, []uint16{width}},
+		{tiffTagImageLength, tiffTypeShort, []uint16{height}},
+		{tiffTagModelPixelScale, tiffTypeDouble, scale},
+		{tiffTagModelTiepoint, tiffTypeDouble, tiepoint},
+		{tiffTagGeoKeyDirectory, tiffTypeShort, []uint16{1, 1, 0, 1, geoKeyProjectedCSType, 0, 1, epsg}},
+	}
+
+	var out, extra bytes.Buffer
+	order := binary.LittleEndian
+	out.WriteString("II")
+	binary.Write(&out, order, uint16(42))
+	binary.Write(&out, order, uint32(8))
+	binary.Write(&out, order, uint16(len(entries)))
+	extraStart := 8 + 2 + len(entries)*classicTIFFEntrySize + 4
+	for _, e := range entries {
+		var data bytes.Buffer
+		binary.Write(&data, order, e.values)
+		count := data.Len() / int(tiffTypeSize(e.typ))
+		binary.Write(&out, order, e.tag)
+		binary.Write(&out, order, e.typ)
+		binary.Write(&out, order, uint32(count))
+		if data.Len() <= classicTIFFInlineValues {
+			value := make([]byte, 4)
+			copy(value, data.Bytes())
+			out.Write(value)
+		} else {
+			binary.Write(&out, order, uint32(extraStart+extra.Len()))
+			extra.Write(data.Bytes())
+		}
+	}
+	binary.Write(&out, order, uint32(0))
+	out.Write(extra.Bytes())
+	return out.Bytes()
+}
+
+func TestGeoTIFFSpatMeta(t *testing.T) {
+	data := testGeoTIFF(200, 100, []float64{0, 0, 0, 500000, 4000000, 0}, []float64{30, 30, 0}, 32617)
+	actual, err := GeoTIFFSpatMeta(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("TestGeoTIFFSpatMeta: " + err.Error())
+	}
+	expected := SpatMeta{MinX: 500000, MinY: 3997000, MaxX: 506000, MaxY: 4000000, EpsgCode: 32617, CoordRefSystem: "EPSG:32617"}
+	if *actual != expected {
+		t.Errorf("TestGeoTIFFSpatMeta: expected %+v, got %+v", expected, *actual)
+	}
+
+	if _, err := GeoTIFFSpatMeta(bytes.NewReader([]byte("II*\x00\x08\x00\x00\x00\x00\x00"))); err == nil {
+		t.Error("TestGeoTIFFSpatMeta: accepted a TIFF with no tags")
+	}
+	if _, err := GeoTIFFSpatMeta(bytes.NewReader([]byte("not a tiff"))); err == nil {
+		t.Error("TestGeoTIFFSpatMeta: accepted a file that is not a TIFF")
+	}
+}
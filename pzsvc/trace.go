@@ -0,0 +1,358 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceParentHeader is the W3C Trace Context header used to carry a span's
+// identity across process boundaries
+const TraceParentHeader = "traceparent"
+
+const traceBatchSize = 100
+
+var traceParentRegexp = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// Span is a single timed operation within a trace, following the OpenTelemetry
+// model.  Spans are created with StartSpan, and exported when ended.  All
+// methods are safe to call on a nil Span, which does nothing.
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Kind       int // OTLP span kind: 1 for internal, 3 for client
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]string
+	ErrMsg     string
+	mu         sync.Mutex
+}
+
+// StartSpan begins a new span as a child of the given parent.  If the parent
+// is nil, the span begins a new trace.
+func StartSpan(parent *Span, name string) *Span {
+	span := &Span{SpanID: randomHex(8), Name: name, Kind: 1, StartTime: time.Now(), Attributes: map[string]string{}}
+	if parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = randomHex(16)
+	}
+	return span
+}
+
+// StartSpanFromTraceParent begins a new span as a child of the span described
+// by the given traceparent value, as created by TraceParent.  If the value is
+// blank or malformed, the span begins a new trace.
+func StartSpanFromTraceParent(traceParent, name string) *Span {
+	matches := traceParentRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(traceParent)))
+	if matches == nil {
+		return StartSpan(nil, name)
+	}
+	return StartSpan(&Span{TraceID: matches[1], SpanID: matches[2]}, name)
+}
+
+// TraceParent returns the W3C traceparent value identifying this span, for
+// passing to another process
+func (sp *Span) TraceParent() string {
+	if sp == nil {
+		return ""
+	}
+	return "00-" + sp.TraceID + "-" + sp.SpanID + "-01"
+}
+
+// SetAttribute attaches a key/value pair to the span
+func (sp *Span) SetAttribute(key, value string) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.Attributes[key] = Redact(value)
+}
+
+// SetError marks the span as failed, with the given error as the reason.  A
+// nil error is ignored.
+func (sp *Span) SetError(err error) {
+	if sp == nil || err == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.ErrMsg = Redact(err.Error())
+}
+
+// End ends the span, and queues it for export.  Only the first call has
+// any effect.
+func (sp *Span) End() {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	if !sp.EndTime.IsZero() {
+		sp.mu.Unlock()
+		return
+	}
+	sp.EndTime = time.Now()
+	sp.mu.Unlock()
+	tracer.add(sp)
+}
+
+// traceExporter sends a batch of finished spans somewhere
+type traceExporter interface {
+	export(body []byte) error
+}
+
+// fileTraceExporter appends each batch of spans to a file, as a single line
+// of OTLP JSON
+type fileTraceExporter struct {
+	path string
+}
+
+func (fe fileTraceExporter) export(body []byte) error {
+	file, err := os.OpenFile(fe.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(body, '\n'))
+	return err
+}
+
+// otlpTraceExporter posts each batch of spans to an OTLP/HTTP collector
+type otlpTraceExporter struct {
+	url string
+}
+
+func (oe otlpTraceExporter) export(body []byte) error {
+	resp, err := HTTPClient().Post(oe.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("received " + http.StatusText(resp.StatusCode) + " from trace collector " + oe.url)
+	}
+	return nil
+}
+
+// spanTracer batches finished spans for export
+type spanTracer struct {
+	mu          sync.Mutex
+	serviceName string
+	exporter    traceExporter
+	pending     []*Span
+}
+
+var tracer = &spanTracer{}
+
+func (st *spanTracer) add(sp *Span) {
+	st.mu.Lock()
+	if st.exporter == nil {
+		st.mu.Unlock()
+		return
+	}
+	st.pending = append(st.pending, sp)
+	full := len(st.pending) >= traceBatchSize
+	st.mu.Unlock()
+	if full {
+		st.flush()
+	}
+}
+
+func (st *spanTracer) flush() error {
+	st.mu.Lock()
+	spans := st.pending
+	st.pending = nil
+	exporter := st.exporter
+	serviceName := st.serviceName
+	st.mu.Unlock()
+	if exporter == nil || len(spans) == 0 {
+		return nil
+	}
+	return exporter.export(otlpJSON(serviceName, spans))
+}
+
+// SetupTracing configures where finished spans are exported, based on the
+// TraceExporter and TraceEndpoint settings of the given config.  Until it is
+// called, or if TraceExporter is blank, spans are discarded.  Spans are sent
+// in batches, from a background routine, and on calls to FlushTraces.
+func SetupTracing(s Session, serviceName string, config Config) LoggedError {
+	var exporter traceExporter
+	switch config.TraceExporter {
+	case "":
+		return nil
+	case "file":
+		if config.TraceEndpoint == "" {
+			return LogSimpleErr(s, "Config: file trace exporter requires a TraceEndpoint path.", nil)
+		}
+		exporter = fileTraceExporter{path: config.TraceEndpoint}
+	case "otlp":
+		endpoint := config.TraceEndpoint
+		if endpoint == "" {
+			endpoint = "http://localhost:4318"
+		}
+		if !strings.HasSuffix(endpoint, "/v1/traces") {
+			endpoint = strings.TrimRight(endpoint, "/") + "/v1/traces"
+		}
+		exporter = otlpTraceExporter{url: endpoint}
+	default:
+		return LogSimpleErr(s, "Config: unknown TraceExporter \""+config.TraceExporter+"\".", nil)
+	}
+
+	tracer.mu.Lock()
+	alreadyRunning := tracer.exporter != nil
+	tracer.serviceName = serviceName
+	tracer.exporter = exporter
+	tracer.mu.Unlock()
+
+	if !alreadyRunning {
+		go func() {
+			for {
+				time.Sleep(5 * time.Second)
+				if err := tracer.flush(); err != nil {
+					LogWarn(s, "Could not export trace spans: "+err.Error())
+				}
+			}
+		}()
+	}
+	LogInfo(s, "Config: Exporting trace spans to "+config.TraceExporter+" "+config.TraceEndpoint)
+	return nil
+}
+
+// FlushTraces exports any finished spans that have not yet been sent.  Call
+// it before exiting, so that the last spans are not lost.
+func FlushTraces(s Session) {
+	if err := tracer.flush(); err != nil {
+		LogWarn(s, "Could not export trace spans: "+err.Error())
+	}
+}
+
+// otlpJSON renders the given spans as an OTLP/JSON ExportTraceServiceRequest
+func otlpJSON(serviceName string, spans []*Span) []byte {
+	type otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	type otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	type otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	type otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	outSpans := make([]otlpSpan, 0, len(spans))
+	for _, sp := range spans {
+		sp.mu.Lock()
+		keys := make([]string, 0, len(sp.Attributes))
+		for key := range sp.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		attrs := []otlpAttribute{}
+		for _, key := range keys {
+			attrs = append(attrs, otlpAttribute{Key: key, Value: otlpValue{sp.Attributes[key]}})
+		}
+		status := otlpStatus{Code: 1}
+		if sp.ErrMsg != "" {
+			status = otlpStatus{Code: 2, Message: sp.ErrMsg}
+		}
+		outSpans = append(outSpans, otlpSpan{
+			TraceID:           sp.TraceID,
+			SpanID:            sp.SpanID,
+			ParentSpanID:      sp.ParentID,
+			Name:              sp.Name,
+			Kind:              sp.Kind,
+			StartTimeUnixNano: strconv.FormatInt(sp.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sp.EndTime.UnixNano(), 10),
+			Attributes:        attrs,
+			Status:            status,
+		})
+		sp.mu.Unlock()
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{serviceName}}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "pzsvc"},
+				"spans": outSpans,
+			}},
+		}},
+	})
+	return body
+}
+
+// startHTTPSpan begins a client span for an outgoing HTTP call, and adds its
+// trace context to the request.  Calls made outside of any trace are not
+// recorded, and return a nil span.
+func startHTTPSpan(parent *Span, req *http.Request) *Span {
+	if parent == nil {
+		return nil
+	}
+	span := StartSpan(parent, "HTTP "+req.Method+" "+metricEndpoint(req.URL.String()))
+	span.Kind = 3
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	req.Header.Set(TraceParentHeader, span.TraceParent())
+	return span
+}
+
+// finishHTTPSpan records the outcome of an HTTP call on its span, and ends it
+func finishHTTPSpan(span *Span, resp *http.Response, err error) {
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			span.SetError(errors.New(http.StatusText(resp.StatusCode)))
+		}
+	}
+	span.End()
+}
+
+func randomHex(n int) string {
+	byts := make([]byte, n)
+	rand.Read(byts)
+	return hex.EncodeToString(byts)
+}
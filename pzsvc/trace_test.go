@@ -0,0 +1,106 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pzsvc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTraceParent(t *testing.T) {
+	root := StartSpan(nil, "root")
+	child := StartSpanFromTraceParent(root.TraceParent(), "child")
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+		t.Error(`TestTraceParent: child did not continue the trace of "` + root.TraceParent() + `"`)
+	}
+	if len(root.TraceID) != 32 || len(root.SpanID) != 16 {
+		t.Error(`TestTraceParent: bad IDs in "` + root.TraceParent() + `"`)
+	}
+
+	orphan := StartSpanFromTraceParent("garbage", "orphan")
+	if orphan.ParentID != "" || orphan.TraceID == root.TraceID {
+		t.Error(`TestTraceParent: malformed traceparent did not start a new trace`)
+	}
+
+	var nilSpan *Span
+	nilSpan.SetAttribute("a", "b")
+	nilSpan.End()
+	if nilSpan.TraceParent() != "" {
+		t.Error(`TestTraceParent: nil span returned a traceparent`)
+	}
+}
+
+func TestTraceExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pzsvc-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spans.jsonl")
+
+	s := Session{AppName: "test", SessionID: "test"}
+	if err := SetupTracing(s, "trace-test", Config{TraceExporter: "file", TraceEndpoint: path}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tracer = &spanTracer{} }()
+
+	var gotHeader string
+	SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		gotHeader = req.Header.Get(TraceParentHeader)
+		return &http.Response{StatusCode: 200, Body: GetMockReadCloser(`{}`), Header: http.Header{}}, nil
+	})})
+	defer SetHTTPClient(nil)
+
+	root := StartSpan(nil, "root")
+	s.Span = root
+	s.PzAddr = "http://pz.test"
+	if err := CheckAuth(s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(gotHeader, "00-"+root.TraceID+"-") || gotHeader == root.TraceParent() {
+		t.Error(`TestTraceExport: call carried traceparent "` + gotHeader + `", expected a child of ` + root.TraceParent())
+	}
+	root.SetAttribute("job.id", "job-1")
+	root.End()
+	FlushTraces(s)
+
+	byts, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(byts)
+	expected := []string{
+		`"stringValue":"trace-test"`,
+		`"name":"root"`,
+		`"name":"HTTP GET /service"`,
+		`"parentSpanId":"` + root.SpanID + `"`,
+		`"key":"job.id","value":{"stringValue":"job-1"}`,
+	}
+	for _, part := range expected {
+		if !strings.Contains(out, part) {
+			t.Error(`TestTraceExport: export missing ` + part + `: ` + out)
+		}
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
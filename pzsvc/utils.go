@@ -38,6 +38,11 @@ var (
 	// LogFunc is the logging function in use by this instance.
 	// It exists as a way to easily control where your logs go.
 	LogFunc = baseLogFunc
+
+	// AuditLogFunc is the logging function used for audit entries.  It
+	// exists so that the audit trail can be sent somewhere other than the
+	// general logs.
+	AuditLogFunc = baseLogFunc
 )
 
 // LoggedError is a duplicate of the "error" interface.  Its real point is to
@@ -63,7 +68,16 @@ const (
 // and puts it in the right place.  This function exists partially in order
 // to simplify the task of modifying log behavior in the future.
 func logMessage(s Session, severity int, msg string) {
-	funcPtr, file, line, _ := runtime.Caller(2)
+	if severity > logLevel {
+		return
+	}
+	LogFunc(buildLogEntry(s, severity, msg, nil).format())
+}
+
+// buildLogEntry gathers the details of a log entry.  It expects to be called
+// from logMessage or LogAudit, and records the location of their caller.
+func buildLogEntry(s Session, severity int, msg string, audit *auditDetails) logEntry {
+	funcPtr, file, line, _ := runtime.Caller(3)
 	fname := runtime.FuncForPC(funcPtr).Name()
 	if s.LogRootDir != "" {
 		splits := strings.SplitAfter(file, s.LogRootDir)
@@ -71,12 +85,37 @@ func logMessage(s Session, severity int, msg string) {
 			file = s.LogRootDir + splits[len(splits)-1]
 		}
 	}
-	time := time.Now().UTC().Format("2006-01-02T15:04:05.999Z")
+
+	fields := s.LogFields
+	if s.Span != nil {
+		fields = s.WithField("traceID", s.Span.TraceID).WithField("spanID", s.Span.SpanID).LogFields
+	}
 
 	hostName, _ := os.Hostname()
-	outMsg := fmt.Sprintf(`<%d>1 %s %s %s - ID%d [pzsource@48851 file="%s" line="%d" function="%s"] %s`,
-		8+severity, time, hostName, s.AppName, os.Getpid(), file, line, fname, msg)
-	LogFunc(outMsg)
+	return logEntry{
+		Time:     time.Now(),
+		Severity: severity,
+		Host:     hostName,
+		App:      s.AppName,
+		PID:      os.Getpid(),
+		File:     file,
+		Line:     line,
+		Function: fname,
+		Message:  Redact(msg),
+		Fields:   redactFields(fields),
+		Audit:    audit.redact(),
+	}
+}
+
+func redactFields(fields map[string]string) map[string]string {
+	if len(fields) == 0 {
+		return fields
+	}
+	out := make(map[string]string, len(fields))
+	for key, value := range fields {
+		out[key] = Redact(value)
+	}
+	return out
 }
 
 // LogSimpleErr posts a logMessage call for simple error messages, and produces a pzsvc.Error
@@ -86,7 +125,7 @@ func LogSimpleErr(s Session, message string, err error) LoggedError {
 		message += err.Error()
 	}
 	logMessage(s, 3, message)
-	return fmt.Errorf(message)
+	return errors.New(Redact(message))
 }
 
 // LogInfo posts a logMessage call for standard, non-error messages.  The
@@ -118,8 +157,16 @@ func LogAlert(s Session, message string) {
 // when routing requirements change.
 func LogAudit(s Session, actor, action, actee, msg string, severity int) {
 	if s.LogAudit {
-		outMsg := fmt.Sprintf(`[pzaudit@48851 actor="%s" action="%s" actee="%s"] %s`, actor, action, actee, msg)
-		logMessage(s, severity, outMsg)
+		logAuditMessage(s, severity, msg, &auditDetails{Actor: actor, Action: action, Actee: actee})
+	}
+}
+
+// logAuditMessage is logMessage for audit entries, which go to their own sink
+// and are never filtered by level
+func logAuditMessage(s Session, severity int, msg string, audit *auditDetails) {
+	entry := buildLogEntry(s, severity, msg, audit)
+	if !writeAudit(entry) {
+		AuditLogFunc(entry.format())
 	}
 }
 
@@ -203,7 +250,7 @@ func (err *PzCustomError) Log(s Session, msgAdd string) LoggedError {
 		logMessage(s, 3, "Meta-error.  Tried to log same message for a second time.")
 	}
 
-	return errors.New(err.Error())
+	return errors.New(Redact(err.Error()))
 
 }
 
@@ -43,8 +43,12 @@ func init() {
 		cli.StringFlag{Name: "userID", Usage: "key authentication string (required)"},
 		cli.StringFlag{Name: "serviceID", Usage: "piazza service ID (algorithm name) (required)"},
 		cli.StringFlag{Name: "jobID", Usage: "job ID for this run, used for logging"},
+		cli.StringFlag{Name: "traceparent", Usage: "W3C trace context of the dispatcher span that launched this job"},
 		cli.StringSliceFlag{Name: "input, i", Usage: "input source specification (as \"filename:URL\")"},
 		cli.StringSliceFlag{Name: "output, o", Usage: "output file name (usable multiple times; at least one required)"},
+		cli.StringSliceFlag{Name: "deploy", Usage: "output to deploy to GeoServer once ingested (usable multiple times; each must also be an output)"},
+		cli.StringSliceFlag{Name: "attribute", Usage: "job attribute to record with the outputs (as \"key=value\"; usable multiple times)"},
+		cli.StringFlag{Name: "classification", Usage: "classification of the job's outputs (defaults to the service's)"},
 	}
 }
 
@@ -57,8 +61,11 @@ func runCmd(ctx *cli.Context) error {
 		PiazzaServiceID: ctx.String("serviceID"),
 		UserID:          ctx.String("userID"),
 		JobID:           ctx.String("jobID"),
+		TraceParent:     ctx.String("traceparent"),
 		Inputs:          []config.InputSource{},
 		Outputs:         ctx.StringSlice("output"),
+		Deploy:          ctx.StringSlice("deploy"),
+		Attributes:      map[string]string{},
 		PzSEConfig:      pzsvc.Config{},
 	}
 	workerlog.Info(cfg, "startup")
@@ -69,6 +76,16 @@ func runCmd(ctx *cli.Context) error {
 	if err := cfg.ReadPzSEConfig(ctx.String("config")); err != nil {
 		return cli.NewExitError(err, 1)
 	}
+	if err := pzsvc.SetupLogging(cfg.PzSEConfig); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if err := pzsvc.SetupRedaction(cfg.PzSEConfig); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if err := pzsvc.SetupAuditSinks(*cfg.Session, cfg.PzSEConfig); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer pzsvc.FlushAudit()
 
 	if cfg.PiazzaServiceID == "" {
 		return cli.NewExitError("Service ID is required", 1)
@@ -88,12 +105,19 @@ func runCmd(ctx *cli.Context) error {
 	if cfg.PiazzaAPIKey == "" {
 		return cli.NewExitError("Piazza API key is required", 1)
 	}
+	pzsvc.RegisterSecret(cfg.PiazzaAPIKey)
 	cfg.Session.PzAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(cfg.PiazzaAPIKey+":"))
 
 	if len(cfg.Outputs) == 0 {
 		return cli.NewExitError("1 or more output files are required", 1)
 	}
 
+	for _, deploy := range cfg.Deploy {
+		if !isOutput(cfg, deploy) {
+			return cli.NewExitError(fmt.Sprintf("deployed output `%s` is not one of the outputs", deploy), 1)
+		}
+	}
+
 	for _, sourceString := range ctx.StringSlice("input") {
 		inFile, err := config.ParseInputSource(sourceString)
 		if err != nil {
@@ -102,10 +126,29 @@ func runCmd(ctx *cli.Context) error {
 		cfg.Inputs = append(cfg.Inputs, *inFile)
 	}
 
+	for _, attributeString := range ctx.StringSlice("attribute") {
+		key, value, err := config.ParseAttribute(attributeString)
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		cfg.Attributes[key] = value
+	}
+
+	class, err := pzsvc.JobClassification(cfg.PzSEConfig, ctx.String("classification"))
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	cfg.Session.Class = class
+
 	workerlog.Info(cfg, fmt.Sprintf("config validated: %s", cfg.Serialize()))
 
+	if err := pzsvc.SetupTracing(*cfg.Session, "pzsvc-worker", cfg.PzSEConfig); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer pzsvc.FlushTraces(*cfg.Session)
+
 	workerlog.Info(cfg, "Starting actual worker execution")
-	err := workerexec.WorkerExec(cfg)
+	err = workerexec.WorkerExec(cfg)
 	if err != nil {
 		workerlog.SimpleErr(cfg, "execution error, quitting with status 1", err)
 		return cli.NewExitError(err, 1)
@@ -115,6 +158,16 @@ func runCmd(ctx *cli.Context) error {
 	return nil
 }
 
+// isOutput returns whether the given name is one of the job's outputs
+func isOutput(cfg config.WorkerConfig, name string) bool {
+	for _, output := range cfg.Outputs {
+		if output == name {
+			return true
+		}
+	}
+	return false
+}
+
 func main() {
 	cliApp.Run(os.Args)
 }
@@ -15,6 +15,8 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -27,6 +29,7 @@ import (
 type InputSource struct {
 	FileName string
 	URL      string
+	SHA256   string `json:",omitempty"` // Checksum of the file, once downloaded
 }
 
 // ParseInputSource takes a colon-separates input source string and turns it
@@ -51,12 +54,17 @@ type WorkerConfig struct {
 	CLICommandExtra string
 	UserID          string
 	JobID           string
+	TraceParent     string
 	Inputs          []InputSource
 	Outputs         []string
+	Deploy          []string
+	Attributes      map[string]string
 	PzSEConfig      pzsvc.Config
+	ConfigSHA256    string
 }
 
-// ReadPzSEConfig reads the pzsvc-exec.config data from the given path
+// ReadPzSEConfig reads the pzsvc-exec.config data from the given path, and
+// records its checksum
 func (wc *WorkerConfig) ReadPzSEConfig(path string) error {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -64,6 +72,8 @@ func (wc *WorkerConfig) ReadPzSEConfig(path string) error {
 	}
 
 	err = json.Unmarshal(data, &wc.PzSEConfig)
+	hash := sha256.Sum256(data)
+	wc.ConfigSHA256 = hex.EncodeToString(hash[:])
 	return err
 }
 
@@ -81,3 +91,13 @@ func (wc WorkerConfig) InputsAsMap() map[string]string {
 	}
 	return converted
 }
+
+// ParseAttribute takes a "key=value" attribute string and splits it into its
+// key and value
+func ParseAttribute(attributeString string) (string, string, error) {
+	parts := strings.SplitN(attributeString, "=", 2)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("Invalid attribute string: %s", attributeString)
+	}
+	return parts[0], parts[1], nil
+}
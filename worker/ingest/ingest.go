@@ -15,59 +15,104 @@
 package ingest
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/venicegeo/pzsvc-exec/pzsvc"
 	"github.com/venicegeo/pzsvc-exec/worker/config"
 	"github.com/venicegeo/pzsvc-exec/worker/log"
+	"github.com/venicegeo/pzsvc-exec/worker/validate"
 )
 
-const ingestTimeout = 1 * time.Minute
+// defaultIngestTimeout is the time allowed for each file's ingest, before
+// adding the time allowed for its size
+const defaultIngestTimeout = 1 * time.Minute
+
+// ingestTimeoutPerMB is the further time allowed for each MB of a file
+const ingestTimeoutPerMB = 1 * time.Second
+
+// ingestTimeout returns the time allowed for the ingest of a file of the
+// given size, covering both its upload and the wait for Piazza to take it
+func ingestTimeout(cfg config.WorkerConfig, size int64) time.Duration {
+	timeout := defaultIngestTimeout
+	if cfg.PzSEConfig.IngestTimeout > 0 {
+		timeout = time.Duration(cfg.PzSEConfig.IngestTimeout) * time.Second
+	}
+	return timeout + time.Duration(size/(1024*1024))*ingestTimeoutPerMB
+}
 
 // MultiIngestOutput holds response data for batch-ingesting several files
 type MultiIngestOutput struct {
 	DataIDs       map[string]string
+	ProvenanceID  string // Data ID of the PROV-JSON sidecar, if one was ingested
+	Deployments   []pzsvc.DeplStrct
 	Errors        []error
 	CombinedError error
 }
 
+type singleDeployOutput struct {
+	FilePath   string
+	Deployment *pzsvc.DeplStrct
+	Error      error
+}
+
 type singleIngestOutput struct {
 	FilePath string
 	DataID   string
 	Error    error
 }
 
-// OutputFilesToPiazza ingests the given files into the Piazza system
-func OutputFilesToPiazza(cfg config.WorkerConfig, algFullCommand string, algVersion string) (output MultiIngestOutput) {
+// OutputFilesToPiazza ingests the job's output files into the Piazza system.
+// Each output may name a file, a glob pattern or a directory; see
+// expandOutputs.  The provenance of the outputs is recorded in their
+// metadata, and if so configured, in a PROV-JSON sidecar.  Outputs the job
+// asked to deploy are deployed to GeoServer once they have been ingested.
+// partial marks the outputs of a failed run, of which only those that exist
+// are ingested.
+func OutputFilesToPiazza(cfg config.WorkerConfig, provenance Provenance, partial bool) (output MultiIngestOutput) {
 	output.DataIDs = map[string]string{}
+	checksums := map[string]string{}
+	fileTypes := map[string]string{}
 	ingestResultChans := []<-chan singleIngestOutput{}
 
-	for _, filePath := range cfg.Outputs {
+	filePaths, deployFiles, expandErrors := expandOutputs(cfg, partial)
+	output.Errors = append(output.Errors, expandErrors...)
+	if validateErrors := validate.Outputs(cfg, filePaths); len(validateErrors) > 0 {
+		// Nothing is ingested from a job whose outputs break the rules
+		output.Errors = append(output.Errors, validateErrors...)
+		filePaths = nil
+	}
+
+	for _, filePath := range filePaths {
 		workerlog.Info(cfg, "ingesting file to Piazza: "+filePath)
-		if _, fStatErr := os.Stat(filePath); fStatErr != nil {
-			errMsg := fmt.Sprintf("error statting file `%s`: %v", filePath, fStatErr)
-			workerlog.SimpleErr(cfg, errMsg, fStatErr)
-			output.Errors = append(output.Errors, errors.New(errMsg))
+		fileType, err := pzsvc.DetectFileType(filePath)
+		if err != nil {
+			workerlog.SimpleErr(cfg, "cannot ingest file "+filePath, err)
+			output.Errors = append(output.Errors, fmt.Errorf("cannot ingest file `%s`: %v", filePath, err))
 			continue
 		}
 
-		fileType := detectPiazzaFileType(filePath)
-
-		attMap := map[string]string{
-			"algoName":     cfg.PiazzaServiceID,
-			"algoVersion":  algVersion,
-			"algoCmd":      algFullCommand,
-			"algoProcTime": time.Now().UTC().Format("20060102.150405.99999"),
+		checksum, err := fileSHA256(filePath)
+		if err != nil {
+			workerlog.SimpleErr(cfg, "cannot checksum file "+filePath, err)
+			output.Errors = append(output.Errors, fmt.Errorf("cannot checksum file `%s`: %v", filePath, err))
+			continue
 		}
+		checksums[filePath] = checksum
+		fileTypes[filePath] = fileType
+		attMap := provenance.metadata(cfg, checksum)
 
 		workerlog.Info(cfg, fmt.Sprintf("async ingest call: path=%s type=%s serviceID=%s, version=%s, attMap=%v",
-			filePath, fileType, cfg.PiazzaServiceID, algVersion, attMap))
-		resultChan := ingestFileAsync(*cfg.Session, filePath, fileType, cfg.PiazzaServiceID, algVersion, attMap)
+			filePath, fileType, cfg.PiazzaServiceID, provenance.Version, attMap))
+		var size int64
+		if info, err := os.Stat(filePath); err == nil {
+			size = info.Size()
+		}
+		resultChan := ingestFileAsync(*cfg.Session, filePath, fileType, cfg.PiazzaServiceID, provenance.Version, attMap, ingestTimeout(cfg, size))
 		ingestResultChans = append(ingestResultChans, resultChan)
 	}
 
@@ -83,6 +128,44 @@ func OutputFilesToPiazza(cfg config.WorkerConfig, algFullCommand string, algVers
 		}
 	}
 
+	deployResultChans := []<-chan singleDeployOutput{}
+	for _, filePath := range filePaths {
+		dataID, ok := output.DataIDs[filePath]
+		if !ok || !deployFiles[filePath] {
+			continue
+		}
+		if !pzsvc.IsDeployable(fileTypes[filePath]) {
+			err := fmt.Errorf("cannot deploy file `%s`: %s data cannot be deployed to GeoServer", filePath, fileTypes[filePath])
+			workerlog.SimpleErr(cfg, "cannot deploy file "+filePath, err)
+			output.Errors = append(output.Errors, err)
+			continue
+		}
+		workerlog.Info(cfg, fmt.Sprintf("async deploy call: path=%s dataID=%s", filePath, dataID))
+		deployResultChans = append(deployResultChans, deployAsync(*cfg.Session, filePath, dataID))
+	}
+
+	for _, resultChan := range deployResultChans {
+		result := <-resultChan
+		if result.Error != nil {
+			workerlog.SimpleErr(cfg, "received async deploy error", result.Error)
+			output.Errors = append(output.Errors, result.Error)
+		} else {
+			workerlog.Info(cfg, fmt.Sprintf("deployed file `%s` as layer %s", result.FilePath, result.Deployment.Layer))
+			output.Deployments = append(output.Deployments, *result.Deployment)
+		}
+	}
+
+	if cfg.PzSEConfig.ProvSidecar && len(output.DataIDs) > 0 {
+		provenanceID, err := provenance.ingestProvenance(cfg, output.DataIDs, checksums)
+		if err != nil {
+			workerlog.SimpleErr(cfg, "provenance sidecar failed", err)
+			output.Errors = append(output.Errors, err)
+		} else {
+			workerlog.Info(cfg, "ingested provenance sidecar as ID: "+provenanceID)
+			output.ProvenanceID = provenanceID
+		}
+	}
+
 	if len(output.Errors) > 0 {
 		errorTexts := []string{}
 		for _, err := range output.Errors {
@@ -96,30 +179,46 @@ func OutputFilesToPiazza(cfg config.WorkerConfig, algFullCommand string, algVers
 	return
 }
 
+// ingestFileAsync ingests the given file, giving up after the given timeout.
+// Giving up cancels the upload and the wait for Piazza.
 func ingestFileAsync(s pzsvc.Session, filePath string, fileType string,
-	serviceID string, algVersion string, attMap map[string]string) <-chan singleIngestOutput {
+	serviceID string, algVersion string, attMap map[string]string, timeout time.Duration) <-chan singleIngestOutput {
 	outChan := make(chan singleIngestOutput)
 
-	// Nested goroutines to allow for 1 minute for ingestion to succeed
 	go func() {
-		resultChan := make(chan singleIngestOutput)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		s.Context = ctx
+
+		// Buffered, so that an ingest that outlives its timeout can still
+		// deliver its result and end
+		resultChan := make(chan singleIngestOutput, 1)
 		go func() {
+			span := pzsvc.StartSpan(s.Span, "ingest "+filePath)
+			span.SetAttribute("file.name", filePath)
+			span.SetAttribute("file.type", fileType)
+			s.Span = span
 			dataID, err := pzsvc.IngestFile(s, filePath, fileType, serviceID, algVersion, attMap)
+			if err != nil {
+				span.SetError(err)
+			} else {
+				span.SetAttribute("data.id", dataID)
+			}
+			span.End()
 
 			resultChan <- singleIngestOutput{
 				FilePath: filePath,
 				DataID:   dataID,
 				Error:    err,
 			}
-			close(resultChan)
 		}()
 		select {
 		case result := <-resultChan:
 			outChan <- result
-		case <-time.After(ingestTimeout):
+		case <-ctx.Done():
 			outChan <- singleIngestOutput{
 				FilePath: filePath,
-				Error:    errors.New("File ingest timed out"),
+				Error:    fmt.Errorf("File ingest timed out after %v", timeout),
 			}
 		}
 		close(outChan)
@@ -128,15 +227,26 @@ func ingestFileAsync(s pzsvc.Session, filePath string, fileType string,
 	return outChan
 }
 
-func detectPiazzaFileType(fileName string) string {
-	ext := filepath.Ext(strings.ToLower(fileName))
-
-	switch ext {
-	case ".geojson":
-		return "geojson"
-	case ".tiff", ".geotiff":
-		return "raster"
-	default:
-		return "text"
-	}
+// deployAsync deploys the ingested data of the given file to GeoServer.
+// Waiting for the deployment is bounded by pzsvc.GetJobResponse.
+func deployAsync(s pzsvc.Session, filePath string, dataID string) <-chan singleDeployOutput {
+	outChan := make(chan singleDeployOutput, 1)
+	go func() {
+		span := pzsvc.StartSpan(s.Span, "deploy "+filePath)
+		span.SetAttribute("file.name", filePath)
+		span.SetAttribute("data.id", dataID)
+		s.Span = span
+		deployment, err := pzsvc.DeployToGeoServer(s, dataID)
+		result := singleDeployOutput{FilePath: filePath, Deployment: deployment}
+		if err != nil {
+			span.SetError(err)
+			result.Error = fmt.Errorf("cannot deploy file `%s`: %v", filePath, err)
+		} else {
+			span.SetAttribute("deployment.layer", deployment.Layer)
+		}
+		span.End()
+		outChan <- result
+		close(outChan)
+	}()
+	return outChan
 }
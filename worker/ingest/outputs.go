@@ -0,0 +1,417 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+	"github.com/venicegeo/pzsvc-exec/worker/log"
+)
+
+// zipPrefix marks an output spec naming a shapefile directory, such as
+// "zip:roads", to be zipped and ingested as one shapefile
+const zipPrefix = "zip:"
+
+// parseOutputSpec splits an output spec into whether it asks for zipping,
+// and its file name, glob pattern or directory
+func parseOutputSpec(spec string) (zipped bool, pattern string) {
+	if strings.HasPrefix(spec, zipPrefix) {
+		return true, strings.TrimPrefix(spec, zipPrefix)
+	}
+	return false, spec
+}
+
+// expandOutputs turns the job's output specs into the list of files to
+// ingest.  A spec may name a file, a glob pattern, or a directory, within the
+// working directory.  The files in a directory are ingested individually,
+// unless the spec asks for the directory to be zipped as a shapefile, in which
+// case the zip archive is ingested in its place.  Errors in one spec do not
+// stop the others from being expanded, but exceeding the configured caps on
+// file count or total size stops the expansion, and fails them all.  The
+// files expanded from specs the job asked to deploy are also returned, as a
+// set.  If skipMissing is set, as it is for a failed run, outputs that do not
+// exist are passed over rather than being errors.
+func expandOutputs(cfg config.WorkerConfig, skipMissing bool) ([]string, map[string]bool, []error) {
+	files := []string{}
+	deployFiles := map[string]bool{}
+	errs := []error{}
+	seen := map[string]bool{}
+	deploy := false
+	var totalBytes int64
+	addFile := func(path string, size int64) error {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+			totalBytes += size
+			if err := checkOutputCaps(cfg, len(files), totalBytes); err != nil {
+				return err
+			}
+		}
+		if deploy {
+			deployFiles[path] = true
+		}
+		return nil
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, nil, append(errs, fmt.Errorf("cannot find the working directory: %v", err))
+	}
+
+	for _, spec := range cfg.Outputs {
+		deploy = isDeployed(cfg, spec)
+		zipped, pattern := parseOutputSpec(spec)
+		paths := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid output pattern `%s`: %v", pattern, err))
+				continue
+			}
+			if len(matches) == 0 && skipMissing {
+				workerlog.Info(cfg, "no output files match "+pattern+"; skipping")
+				continue
+			}
+			if len(matches) == 0 {
+				errs = append(errs, fmt.Errorf("no output files match `%s`", pattern))
+				continue
+			}
+			paths = matches
+		}
+
+		for _, path := range paths {
+			if err := checkOutputPath(workDir, path); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			info, err := os.Stat(path)
+			if os.IsNotExist(err) && skipMissing {
+				workerlog.Info(cfg, "output file "+path+" does not exist; skipping")
+				continue
+			}
+			if err != nil {
+				errMsg := fmt.Sprintf("error statting file `%s`: %v", path, err)
+				workerlog.SimpleErr(cfg, errMsg, err)
+				errs = append(errs, errors.New(errMsg))
+				continue
+			}
+			if !info.IsDir() {
+				if err := addFile(path, info.Size()); err != nil {
+					return nil, nil, append(errs, err)
+				}
+				continue
+			}
+			if zipped {
+				archivePath, err := zipShapefileDirectory(path)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("error zipping directory `%s`: %v", path, err))
+					continue
+				}
+				workerlog.Info(cfg, "zipped output directory "+path+" as "+archivePath)
+				var size int64
+				if archiveInfo, err := os.Stat(archivePath); err == nil {
+					size = archiveInfo.Size()
+				}
+				if err := addFile(archivePath, size); err != nil {
+					return nil, nil, append(errs, err)
+				}
+				continue
+			}
+			var capErr error
+			err = filepath.Walk(path, func(walkPath string, walkInfo os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if walkInfo.Mode().IsRegular() {
+					capErr = addFile(walkPath, walkInfo.Size())
+					return capErr
+				}
+				return nil
+			})
+			if capErr != nil {
+				return nil, nil, append(errs, capErr)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error listing directory `%s`: %v", path, err))
+			}
+		}
+	}
+
+	return files, deployFiles, errs
+}
+
+// checkOutputPath returns an error unless the given path lies within the
+// working directory, once any symbolic links are followed.  The working
+// directory itself is not an output, since it holds the job's inputs too.
+func checkOutputPath(workDir, path string) error {
+	if filepath.IsAbs(path) {
+		return fmt.Errorf("output `%s` is not within the working directory", path)
+	}
+	rel := filepath.Clean(path)
+	if rel == "." {
+		return fmt.Errorf("output `%s` is the working directory itself", path)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("output `%s` is not within the working directory", path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		// Missing files are reported when they are statted
+		return nil
+	}
+	realWorkDir, err := filepath.EvalSymlinks(workDir)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(realWorkDir, resolved)
+	}
+	rel, err = filepath.Rel(realWorkDir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("output `%s` links to outside the working directory", path)
+	}
+	if rel == "." {
+		return fmt.Errorf("output `%s` links to the working directory itself", path)
+	}
+	return nil
+}
+
+// isDeployed returns whether the job asked for the outputs of the given spec
+// to be deployed to GeoServer
+func isDeployed(cfg config.WorkerConfig, spec string) bool {
+	for _, deploy := range cfg.Deploy {
+		if deploy == spec {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOutputCaps returns an error if the given number and total size of
+// files to ingest exceed the configured limits
+func checkOutputCaps(cfg config.WorkerConfig, fileCount int, totalBytes int64) error {
+	maxFiles := cfg.PzSEConfig.MaxOutputFiles
+	if maxFiles > 0 && fileCount > maxFiles {
+		return fmt.Errorf("job produced more than the limit of %d output files", maxFiles)
+	}
+	maxBytes := cfg.PzSEConfig.MaxOutputBytes
+	if maxBytes > 0 && totalBytes > maxBytes {
+		return fmt.Errorf("job produced more than the limit of %d bytes of output files", maxBytes)
+	}
+	return nil
+}
+
+// zipShapefileDirectory writes the files of a directory holding a shapefile
+// to a zip archive beside it, and returns the path of the archive.  Paths
+// within the archive begin with the directory's name.  A directory without
+// the .shp, .shx and .dbf files of a shapefile is refused, as Piazza has no
+// type for other archives.
+func zipShapefileDirectory(dir string) (archivePath string, err error) {
+	dir = filepath.Clean(dir)
+	if err := checkShapefileDirectory(dir); err != nil {
+		return "", err
+	}
+	archivePath = dir + ".zip"
+	// Never overwrite a file that the algorithm may have written itself
+	file, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return "", fmt.Errorf("`%s` already exists", archivePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	zipWriter := zip.NewWriter(file)
+	base := filepath.Dir(dir)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		header.Method = zip.Deflate
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFileTo(writer, path)
+	})
+	if err != nil {
+		zipWriter.Close()
+		return "", err
+	}
+	return archivePath, zipWriter.Close()
+}
+
+// checkShapefileDirectory returns an error unless the directory holds a .shp
+// file, and the .shx and .dbf files that go with it
+func checkShapefileDirectory(dir string) error {
+	found := map[string]bool{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			found[strings.ToLower(filepath.Ext(path))] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, ext := range []string{".shp", ".shx", ".dbf"} {
+		if !found[ext] {
+			return fmt.Errorf("directory holds no %s file; only shapefile directories can be zipped", ext)
+		}
+	}
+	return nil
+}
+
+func copyFileTo(writer io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// OutputSnapshot is the set of paths found under the job's output specs
+// before an algorithm run, so that what the run created can be told apart
+// from what was already there
+type OutputSnapshot map[string]bool
+
+// SnapshotOutputs records every file and directory found under the job's
+// output specs, within the working directory
+func SnapshotOutputs(cfg config.WorkerConfig) (OutputSnapshot, error) {
+	paths, err := matchOutputs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := OutputSnapshot{}
+	for _, path := range paths {
+		err = filepath.Walk(path, func(walkPath string, walkInfo os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			snapshot[walkPath] = true
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing output: %s; %v", path, err)
+		}
+	}
+	return snapshot, nil
+}
+
+// RemoveOutputs deletes whatever a failed run left behind under the job's
+// output specs, so that a retry starts clean and cannot have stale files
+// ingested as its own.  Only files and directories missing from the snapshot
+// taken before the run are deleted, so that anything already there, such as
+// the job's inputs, is kept.  Outputs that lie outside the working directory
+// are skipped.
+func RemoveOutputs(cfg config.WorkerConfig, before OutputSnapshot) error {
+	paths, err := matchOutputs(cfg)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err = removeNewOutputs(path, before); err != nil {
+			return fmt.Errorf("error removing output: %s; %v", path, err)
+		}
+	}
+	return nil
+}
+
+// removeNewOutputs deletes the given path if it is missing from the
+// snapshot, or else whatever within it is missing from the snapshot
+func removeNewOutputs(path string, before OutputSnapshot) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !before[path] {
+		return os.RemoveAll(path)
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = removeNewOutputs(filepath.Join(path, entry.Name()), before); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchOutputs returns the paths named by the job's output specs, with
+// patterns expanded, leaving out any that are not within the working
+// directory.  Paths need not exist.
+func matchOutputs(cfg config.WorkerConfig) ([]string, error) {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the working directory: %v", err)
+	}
+	matched := []string{}
+	for _, spec := range cfg.Outputs {
+		_, pattern := parseOutputSpec(spec)
+		paths := []string{filepath.Clean(pattern)}
+		if strings.ContainsAny(pattern, "*?[") {
+			if paths, err = filepath.Glob(pattern); err != nil {
+				continue
+			}
+		}
+		for _, path := range paths {
+			if checkOutputPath(workDir, path) == nil {
+				matched = append(matched, path)
+			}
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+)
+
+// inTempDir runs the given function with a fresh temporary directory as the
+// working directory
+func inTempDir(t *testing.T, fn func(dir string)) {
+	dir, err := ioutil.TempDir("", "outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(oldDir)
+	fn(dir)
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpandOutputs(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		writeTestFile(t, "a.txt", "a")
+		writeTestFile(t, "out_1.tif", "1")
+		writeTestFile(t, "out_2.tif", "2")
+		writeTestFile(t, filepath.Join("results", "b.txt"), "b")
+		cfg := config.WorkerConfig{
+			Session: &pzsvc.Session{AppName: "test"},
+			Outputs: []string{"a.txt", "out_*.tif", "results", "a.txt"},
+			Deploy:  []string{"out_*.tif"},
+		}
+		files, deployFiles, errs := expandOutputs(cfg, false)
+		if len(errs) != 0 {
+			t.Errorf(`TestExpandOutputs: unexpected errors: %v`, errs)
+		}
+		if len(files) != 4 {
+			t.Errorf(`TestExpandOutputs: expected 4 files, got %v`, files)
+		}
+		if len(deployFiles) != 2 || !deployFiles["out_1.tif"] || !deployFiles["out_2.tif"] {
+			t.Errorf(`TestExpandOutputs: wrong files to deploy: %v`, deployFiles)
+		}
+	})
+}
+
+func TestExpandOutputsOutsideWorkDir(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		writeTestFile(t, filepath.Join("work", "a.txt"), "a")
+		writeTestFile(t, "secret.txt", "s")
+		if err := os.Chdir("work"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(dir, "secret.txt"), "link.txt"); err != nil {
+			t.Skip("cannot create symbolic links: " + err.Error())
+		}
+		cfg := config.WorkerConfig{
+			Session: &pzsvc.Session{AppName: "test"},
+			Outputs: []string{filepath.Join(dir, "secret.txt"), filepath.Join("..", "secret.txt"), "../*.txt", "link.txt", "a.txt"},
+		}
+		files, _, errs := expandOutputs(cfg, false)
+		if len(files) != 1 || files[0] != "a.txt" {
+			t.Errorf(`TestExpandOutputsOutsideWorkDir: expected only a.txt, got %v`, files)
+		}
+		if len(errs) != 4 {
+			t.Errorf(`TestExpandOutputsOutsideWorkDir: expected 4 errors, got %v`, errs)
+		}
+	})
+}
+
+func TestExpandOutputsCaps(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+			writeTestFile(t, filepath.Join("results", name), "1234")
+		}
+		cfg := config.WorkerConfig{
+			Session: &pzsvc.Session{AppName: "test"},
+			Outputs: []string{"results"},
+		}
+		cfg.PzSEConfig.MaxOutputFiles = 2
+		if files, _, errs := expandOutputs(cfg, false); files != nil || len(errs) != 1 {
+			t.Errorf(`TestExpandOutputsCaps: file cap not enforced: %v, %v`, files, errs)
+		}
+		cfg.PzSEConfig.MaxOutputFiles = 0
+		cfg.PzSEConfig.MaxOutputBytes = 10
+		if files, _, errs := expandOutputs(cfg, false); files != nil || len(errs) != 1 {
+			t.Errorf(`TestExpandOutputsCaps: byte cap not enforced: %v, %v`, files, errs)
+		}
+		cfg.PzSEConfig.MaxOutputBytes = 12
+		if files, _, errs := expandOutputs(cfg, false); len(files) != 3 || len(errs) != 0 {
+			t.Errorf(`TestExpandOutputsCaps: files within caps refused: %v, %v`, files, errs)
+		}
+	})
+}
+
+func TestZipShapefileDirectoryExisting(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		for _, name := range []string{"roads.shp", "roads.shx", "roads.dbf"} {
+			writeTestFile(t, filepath.Join("roads", name), "x")
+		}
+		archivePath, err := zipShapefileDirectory("roads")
+		if err != nil || archivePath != "roads.zip" {
+			t.Fatalf(`TestZipShapefileDirectoryExisting: could not zip: %v`, err)
+		}
+		if _, err = zipShapefileDirectory("roads"); err == nil {
+			t.Error(`TestZipShapefileDirectoryExisting: overwrote an existing archive.`)
+		}
+	})
+}
+
+func TestRemoveOutputs(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		writeTestFile(t, "input.tif", "i")
+		writeTestFile(t, filepath.Join("results", "old.txt"), "o")
+		writeTestFile(t, "keep.txt", "k")
+		cfg := config.WorkerConfig{
+			Session: &pzsvc.Session{AppName: "test"},
+			Outputs: []string{"a.txt", "*.tif", "results", "zip:roads", "missing.txt", "../keep.txt"},
+		}
+		before, err := SnapshotOutputs(cfg)
+		if err != nil {
+			t.Fatal(`TestRemoveOutputs: ` + err.Error())
+		}
+
+		writeTestFile(t, "a.txt", "a")
+		writeTestFile(t, "out_1.tif", "1")
+		writeTestFile(t, filepath.Join("results", "sub", "b.txt"), "b")
+		writeTestFile(t, filepath.Join("results", "old.txt"), "overwritten")
+		writeTestFile(t, filepath.Join("roads", "roads.shp"), "x")
+		if err := RemoveOutputs(cfg, before); err != nil {
+			t.Fatal(`TestRemoveOutputs: ` + err.Error())
+		}
+		for _, path := range []string{"a.txt", "out_1.tif", filepath.Join("results", "sub"), "roads"} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Error(`TestRemoveOutputs: output not removed: ` + path)
+			}
+		}
+		for _, path := range []string{"input.tif", "results", filepath.Join("results", "old.txt"), "keep.txt"} {
+			if _, err := os.Stat(path); err != nil {
+				t.Error(`TestRemoveOutputs: removed too much: ` + path)
+			}
+		}
+	})
+}
+
+func TestOutputsWorkDirItself(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		writeTestFile(t, "input.tif", "i")
+		writeTestFile(t, filepath.Join("results", "a.txt"), "a")
+		specs := []string{".", "./", "results/..", "zip:."}
+		if err := os.Symlink(dir, "here"); err == nil {
+			specs = append(specs, "here")
+		}
+		cfg := config.WorkerConfig{
+			Session: &pzsvc.Session{AppName: "test"},
+			Outputs: specs,
+		}
+		files, _, errs := expandOutputs(cfg, false)
+		if len(files) != 0 || len(errs) != len(specs) {
+			t.Errorf(`TestOutputsWorkDirItself: working directory accepted as an output: %v, %v`, files, errs)
+		}
+		if err := RemoveOutputs(cfg, OutputSnapshot{}); err != nil {
+			t.Fatal(`TestOutputsWorkDirItself: ` + err.Error())
+		}
+		if _, err := os.Stat("input.tif"); err != nil {
+			t.Error(`TestOutputsWorkDirItself: working directory emptied`)
+		}
+	})
+}
+
+func TestExpandOutputsSkipMissing(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		writeTestFile(t, "a.txt", "a")
+		cfg := config.WorkerConfig{
+			Session: &pzsvc.Session{AppName: "test"},
+			Outputs: []string{"a.txt", "missing.txt", "out_*.tif"},
+		}
+		if files, _, errs := expandOutputs(cfg, false); len(files) != 1 || len(errs) != 2 {
+			t.Errorf(`TestExpandOutputsSkipMissing: missing outputs not reported: %v, %v`, files, errs)
+		}
+		if files, _, errs := expandOutputs(cfg, true); len(files) != 1 || len(errs) != 0 {
+			t.Errorf(`TestExpandOutputsSkipMissing: missing outputs not skipped: %v, %v`, files, errs)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+	"github.com/venicegeo/pzsvc-exec/worker/log"
+)
+
+// provenanceFileName is the name under which the PROV sidecar is ingested
+const provenanceFileName = "provenance.json"
+
+// Provenance describes the run of the algorithm that made the job's outputs
+type Provenance struct {
+	Command   string
+	Version   string
+	StartTime time.Time
+	Runtime   time.Duration
+	ExitCode  int
+	Attempts  int
+}
+
+// provenanceInput is the record of one input kept in provenance metadata
+type provenanceInput struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// metadata returns the metadata to record with an output file, given its
+// checksum.  The job's own attributes are included, but cannot replace the
+// provenance entries.
+func (p Provenance) metadata(cfg config.WorkerConfig, checksum string) map[string]string {
+	metadata := map[string]string{}
+	for key, value := range cfg.Attributes {
+		metadata[key] = value
+	}
+
+	inputs := []provenanceInput{}
+	for _, input := range cfg.Inputs {
+		inputs = append(inputs, provenanceInput{Name: input.FileName, URL: pzsvc.Redact(input.URL), SHA256: input.SHA256})
+	}
+	inputsJSON, _ := json.Marshal(inputs)
+
+	// algoProcTime keeps the format existing consumers parse; algoIngestTime
+	// gives the same time in the format of the other provenance times.
+	now := time.Now().UTC()
+	provenance := map[string]string{
+		"algoName":       cfg.PiazzaServiceID,
+		"algoVersion":    p.Version,
+		"algoCmd":        pzsvc.Redact(p.Command),
+		"algoProcTime":   now.Format("20060102.150405.99999"),
+		"algoIngestTime": now.Format(time.RFC3339),
+		"algoStartTime":  p.StartTime.UTC().Format(time.RFC3339),
+		"algoRuntime":    strconv.FormatFloat(p.Runtime.Seconds(), 'f', 3, 64),
+		"algoExitCode":   strconv.Itoa(p.ExitCode),
+		"algoAttempts":   strconv.Itoa(p.Attempts),
+		"jobID":          cfg.JobID,
+		"userID":         cfg.UserID,
+		"inputs":         string(inputsJSON),
+		"sha256":         checksum,
+		"workerHost":     workerHost(),
+		"configSHA256":   cfg.ConfigSHA256,
+	}
+	if containerID := os.Getenv("CF_INSTANCE_GUID"); containerID != "" {
+		provenance["containerID"] = containerID
+	}
+	for key, value := range provenance {
+		if _, ok := metadata[key]; ok {
+			workerlog.Warn(cfg, "job attribute "+key+" is replaced by the provenance entry of the same name")
+		}
+		metadata[key] = value
+	}
+	return metadata
+}
+
+func workerHost() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// fileSHA256 returns the hex SHA-256 checksum of the file at the given path
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// provDocument builds a W3C PROV-JSON document relating the ingested outputs
+// to the job that made them, the inputs it used, and the service and user
+// responsible for it.  checksums holds the checksum of each output path.
+func (p Provenance) provDocument(cfg config.WorkerConfig, dataIDs map[string]string, checksums map[string]string) map[string]interface{} {
+	activity := "pz:job/" + cfg.JobID
+	service := "pz:service/" + cfg.PiazzaServiceID
+	user := "pz:user/" + cfg.UserID
+
+	entities := map[string]interface{}{}
+	generated := map[string]interface{}{}
+	used := map[string]interface{}{}
+
+	paths := make([]string, 0, len(dataIDs))
+	for path := range dataIDs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for i, path := range paths {
+		entity := "pz:data/" + dataIDs[path]
+		entities[entity] = map[string]interface{}{
+			"prov:label": path,
+			"pz:sha256":  checksums[path],
+		}
+		generated["_:gen"+strconv.Itoa(i+1)] = map[string]interface{}{
+			"prov:entity":   entity,
+			"prov:activity": activity,
+		}
+	}
+	for i, input := range cfg.Inputs {
+		entity := "pz:input/" + strconv.Itoa(i+1)
+		entities[entity] = map[string]interface{}{
+			"prov:label":    input.FileName,
+			"prov:location": pzsvc.Redact(input.URL),
+			"pz:sha256":     input.SHA256,
+		}
+		used["_:use"+strconv.Itoa(i+1)] = map[string]interface{}{
+			"prov:activity": activity,
+			"prov:entity":   entity,
+		}
+	}
+
+	return map[string]interface{}{
+		"prefix": map[string]string{"pz": cfg.PiazzaBaseURL + "/"},
+		"entity": entities,
+		"activity": map[string]interface{}{
+			activity: map[string]interface{}{
+				"prov:startTime": p.StartTime.UTC().Format(time.RFC3339),
+				"prov:endTime":   p.StartTime.Add(p.Runtime).UTC().Format(time.RFC3339),
+				"pz:command":     pzsvc.Redact(p.Command),
+				"pz:exitCode":    p.ExitCode,
+				"pz:attempts":    p.Attempts,
+				"pz:workerHost":  workerHost(),
+				"pz:configHash":  cfg.ConfigSHA256,
+			},
+		},
+		"agent": map[string]interface{}{
+			service: map[string]interface{}{
+				"prov:type":  "prov:SoftwareAgent",
+				"pz:version": p.Version,
+			},
+			user: map[string]interface{}{"prov:type": "prov:Person"},
+		},
+		"wasGeneratedBy": generated,
+		"used":           used,
+		"wasAssociatedWith": map[string]interface{}{
+			"_:assoc1": map[string]interface{}{"prov:activity": activity, "prov:agent": service},
+			"_:assoc2": map[string]interface{}{"prov:activity": activity, "prov:agent": user},
+		},
+	}
+}
+
+// ingestProvenance ingests the PROV-JSON document for the job's outputs, and
+// returns its data ID
+func (p Provenance) ingestProvenance(cfg config.WorkerConfig, dataIDs map[string]string, checksums map[string]string) (string, error) {
+	document, err := json.MarshalIndent(p.provDocument(cfg, dataIDs, checksums), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not build provenance document: %v", err)
+	}
+	metadata := map[string]string{
+		"algoName": cfg.PiazzaServiceID,
+		"jobID":    cfg.JobID,
+		"userID":   cfg.UserID,
+		"format":   "PROV-JSON",
+	}
+	dataID, err := pzsvc.Ingest(*cfg.Session, provenanceFileName, "text", cfg.PiazzaServiceID, p.Version, document, metadata)
+	if err != nil {
+		return "", fmt.Errorf("could not ingest provenance document: %v", err)
+	}
+	return dataID, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+)
+
+func testProvenanceConfig() config.WorkerConfig {
+	return config.WorkerConfig{
+		Session:         &pzsvc.Session{AppName: "test"},
+		PiazzaBaseURL:   "https://pz.example.com",
+		PiazzaServiceID: "svc-1",
+		JobID:           "job-1",
+		UserID:          "user-1",
+		Inputs:          []config.InputSource{{FileName: "in.tif", URL: "https://example.com/in.tif", SHA256: "abc"}},
+		Attributes:      map[string]string{"project": "roads", "jobID": "spoofed"},
+		ConfigSHA256:    "cfg-hash",
+	}
+}
+
+func TestProvenanceMetadata(t *testing.T) {
+	start := time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC)
+	prov := Provenance{Command: "algo run", Version: "1.2", StartTime: start, Runtime: 1500 * time.Millisecond, ExitCode: 3, Attempts: 2}
+	metadata := prov.metadata(testProvenanceConfig(), "out-hash")
+
+	expected := map[string]string{
+		"algoName":      "svc-1",
+		"algoVersion":   "1.2",
+		"algoCmd":       "algo run",
+		"algoStartTime": "2018-03-04T05:06:07Z",
+		"algoRuntime":   "1.500",
+		"algoExitCode":  "3",
+		"algoAttempts":  "2",
+		"jobID":         "job-1",
+		"userID":        "user-1",
+		"sha256":        "out-hash",
+		"configSHA256":  "cfg-hash",
+		"project":       "roads",
+	}
+	for key, value := range expected {
+		if metadata[key] != value {
+			t.Errorf(`TestProvenanceMetadata: %s was %q, expected %q.`, key, metadata[key], value)
+		}
+	}
+	if _, err := time.Parse("20060102.150405.99999", metadata["algoProcTime"]); err != nil {
+		t.Errorf(`TestProvenanceMetadata: algoProcTime %q not in its old format.`, metadata["algoProcTime"])
+	}
+	if _, err := time.Parse(time.RFC3339, metadata["algoIngestTime"]); err != nil {
+		t.Errorf(`TestProvenanceMetadata: algoIngestTime %q not in RFC 3339 format.`, metadata["algoIngestTime"])
+	}
+	var inputs []provenanceInput
+	if err := json.Unmarshal([]byte(metadata["inputs"]), &inputs); err != nil || len(inputs) != 1 || inputs[0].SHA256 != "abc" {
+		t.Errorf(`TestProvenanceMetadata: bad inputs entry %q.`, metadata["inputs"])
+	}
+}
+
+func TestProvDocument(t *testing.T) {
+	start := time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC)
+	prov := Provenance{Command: "algo run", Version: "1.2", StartTime: start, Runtime: time.Minute, ExitCode: 0, Attempts: 1}
+	dataIDs := map[string]string{"b.tif": "data-b", "a.geojson": "data-a"}
+	checksums := map[string]string{"b.tif": "hash-b", "a.geojson": "hash-a"}
+	document := prov.provDocument(testProvenanceConfig(), dataIDs, checksums)
+
+	entities := document["entity"].(map[string]interface{})
+	if len(entities) != 3 {
+		t.Errorf(`TestProvDocument: expected 3 entities, found %d.`, len(entities))
+	}
+	entity, ok := entities["pz:data/data-a"].(map[string]interface{})
+	if !ok || entity["prov:label"] != "a.geojson" || entity["pz:sha256"] != "hash-a" {
+		t.Errorf(`TestProvDocument: bad output entity %v.`, entity)
+	}
+	if _, ok := entities["pz:input/1"]; !ok {
+		t.Error(`TestProvDocument: input entity missing.`)
+	}
+
+	generated := document["wasGeneratedBy"].(map[string]interface{})
+	if gen, ok := generated["_:gen1"].(map[string]interface{}); !ok || gen["prov:entity"] != "pz:data/data-a" || gen["prov:activity"] != "pz:job/job-1" {
+		t.Errorf(`TestProvDocument: generations not in path order: %v.`, generated)
+	}
+	if len(document["used"].(map[string]interface{})) != 1 {
+		t.Error(`TestProvDocument: expected 1 usage.`)
+	}
+
+	activity := document["activity"].(map[string]interface{})["pz:job/job-1"].(map[string]interface{})
+	if activity["prov:startTime"] != "2018-03-04T05:06:07Z" || activity["prov:endTime"] != "2018-03-04T05:07:07Z" {
+		t.Errorf(`TestProvDocument: bad activity times %v.`, activity)
+	}
+	if prefix := document["prefix"].(map[string]string)["pz"]; prefix != "https://pz.example.com/" {
+		t.Errorf(`TestProvDocument: bad prefix %q.`, prefix)
+	}
+	if _, err := json.Marshal(document); err != nil {
+		t.Error(`TestProvDocument: document does not marshal: ` + err.Error())
+	}
+}
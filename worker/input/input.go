@@ -15,12 +15,15 @@
 package input
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
 	"github.com/venicegeo/pzsvc-exec/worker/config"
 	"github.com/venicegeo/pzsvc-exec/worker/log"
 )
@@ -29,38 +32,67 @@ var httpClient = http.Client{
 	Timeout: 30 * time.Second,
 }
 
-// FetchInputs recovers and writes input files, using the input source configuration
-func FetchInputs(cfg config.WorkerConfig, inputs []config.InputSource) error {
-	inputResults := []chan error{}
+// FetchInputs recovers and writes input files, using the input source configuration.
+// It returns the total number of bytes downloaded, and records the SHA-256
+// checksum of each input in its InputSource.
+func FetchInputs(cfg config.WorkerConfig, inputs []config.InputSource) (int64, error) {
+	inputResults := []chan downloadResult{}
 	for _, source := range inputs {
-		errChan := downloadInputAsync(source)
+		resultChan := downloadInputAsync(source, cfg.Session.Span)
 		workerlog.Info(cfg, fmt.Sprintf("async downloading input: %s; from: %s", source.FileName, source.URL))
-		inputResults = append(inputResults, errChan)
+		inputResults = append(inputResults, resultChan)
 	}
 
 	errors := []error{}
-
-	for i, errChan := range inputResults {
-		err := <-errChan
-		if err != nil {
-			errors = append(errors, fmt.Errorf("error downloading input: %s; %v", inputs[i].FileName, err))
+	var totalBytes int64
+
+	for i, resultChan := range inputResults {
+		result := <-resultChan
+		totalBytes += result.Bytes
+		inputs[i].SHA256 = result.SHA256
+		if result.Err != nil {
+			errors = append(errors, fmt.Errorf("error downloading input: %s; %v", inputs[i].FileName, result.Err))
 		} else {
-			workerlog.Info(cfg, fmt.Sprintf("downloaded input: %s", inputs[i].FileName))
+			workerlog.Info(cfg, fmt.Sprintf("downloaded input: %s (%d bytes)", inputs[i].FileName, result.Bytes))
 		}
 	}
 
 	if len(errors) > 0 {
-		return fmt.Errorf("%v", errors)
+		return totalBytes, fmt.Errorf("%v", errors)
+	}
+	return totalBytes, nil
+}
+
+// RemoveInputs deletes the downloaded input files, so that they can be
+// downloaded again.  Inputs that are already gone are skipped.
+func RemoveInputs(inputs []config.InputSource) error {
+	for _, source := range inputs {
+		if err := os.Remove(source.FileName); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing input: %s; %v", source.FileName, err)
+		}
 	}
 	return nil
 }
 
-func downloadInputAsync(source config.InputSource) chan error {
-	errChan := make(chan error)
+type downloadResult struct {
+	Bytes  int64
+	SHA256 string
+	Err    error
+}
+
+func downloadInputAsync(source config.InputSource, parentSpan *pzsvc.Span) chan downloadResult {
+	resultChan := make(chan downloadResult, 1)
 
 	go func() {
 		var err error
-		defer close(errChan)
+		defer close(resultChan)
+
+		span := pzsvc.StartSpan(parentSpan, "download "+source.FileName)
+		span.SetAttribute("file.name", source.FileName)
+		defer func() {
+			span.SetError(err)
+			span.End()
+		}()
 
 		_, fStatErr := os.Stat(source.FileName)
 		if fStatErr == nil {
@@ -69,7 +101,7 @@ func downloadInputAsync(source config.InputSource) chan error {
 			err = fmt.Errorf("Error statting file: %v; %v", source.FileName, fStatErr)
 		}
 		if err != nil {
-			errChan <- err
+			resultChan <- downloadResult{Err: err}
 			return
 		}
 
@@ -78,28 +110,26 @@ func downloadInputAsync(source config.InputSource) chan error {
 			err = fmt.Errorf("Unexpected HTTP status: %v", resp.StatusCode)
 		}
 		if err != nil {
-			errChan <- err
+			resultChan <- downloadResult{Err: err}
 			return
 		}
 
 		f, err := os.OpenFile(source.FileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 		if err != nil {
-			errChan <- err
+			resultChan <- downloadResult{Err: err}
 			return
 		}
 
-		_, err = io.Copy(f, resp.Body)
+		hash := sha256.New()
+		n, err := io.Copy(io.MultiWriter(f, hash), resp.Body)
 		if err != nil {
-			errChan <- err
+			resultChan <- downloadResult{Bytes: n, Err: err}
 			return
 		}
 
 		err = f.Close()
-		if err != nil {
-			errChan <- err
-			return
-		}
+		resultChan <- downloadResult{Bytes: n, SHA256: hex.EncodeToString(hash.Sum(nil)), Err: err}
 	}()
 
-	return errChan
+	return resultChan
 }
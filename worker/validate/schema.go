@@ -0,0 +1,263 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// schema is a JSON Schema.  Only a subset of JSON Schema is supported: the
+// keywords type, enum, const, properties, required, additionalProperties,
+// items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum,
+// allOf, anyOf and oneOf.  Other keywords are ignored, except for $ref, which
+// is refused rather than being silently skipped.
+type schema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// loadSchema reads a JSON Schema from the given path
+func loadSchema(path string) (*schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	s := &schema{root: raw, patterns: map[string]*regexp.Regexp{}}
+	if err := s.compile(raw); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile checks a schema and its subschemas for keywords that cannot be
+// supported, and compiles their patterns
+func (s *schema) compile(raw interface{}) error {
+	switch node := raw.(type) {
+	case bool:
+		return nil
+	case map[string]interface{}:
+		if _, ok := node["$ref"]; ok {
+			return errors.New("$ref is not supported")
+		}
+		if pattern, ok := node["pattern"].(string); ok {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("bad pattern %q: %v", pattern, err)
+			}
+			s.patterns[pattern] = compiled
+		}
+		for _, child := range node {
+			switch child.(type) {
+			case map[string]interface{}, []interface{}:
+				if err := s.compile(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case []interface{}:
+		for _, child := range node {
+			if err := s.compile(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// validate checks a decoded JSON value against the schema
+func (s *schema) validate(value interface{}, path string) error {
+	return s.validateChild(s.root, value, path)
+}
+
+func (s *schema) validateNode(node map[string]interface{}, value interface{}, path string) error {
+	if types, ok := node["type"]; ok && !matchesType(types, value) {
+		return fmt.Errorf("%s: expected type %v, found %s", path, types, jsonType(value))
+	}
+	if enum, ok := node["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || reflect.DeepEqual(allowed, value)
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of %v", path, enum)
+		}
+	}
+	if constant, ok := node["const"]; ok && !reflect.DeepEqual(constant, value) {
+		return fmt.Errorf("%s: value must be %v", path, constant)
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(node, typed, path); err != nil {
+			return err
+		}
+	case []interface{}:
+		if min, ok := node["minItems"].(float64); ok && float64(len(typed)) < min {
+			return fmt.Errorf("%s: has %d items, fewer than %v", path, len(typed), min)
+		}
+		if max, ok := node["maxItems"].(float64); ok && float64(len(typed)) > max {
+			return fmt.Errorf("%s: has %d items, more than %v", path, len(typed), max)
+		}
+		for i, item := range typed {
+			if err := s.validateChild(node["items"], item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(typed))
+		if min, ok := node["minLength"].(float64); ok && length < min {
+			return fmt.Errorf("%s: shorter than %v characters", path, min)
+		}
+		if max, ok := node["maxLength"].(float64); ok && length > max {
+			return fmt.Errorf("%s: longer than %v characters", path, max)
+		}
+		if pattern, ok := node["pattern"].(string); ok && !s.patterns[pattern].MatchString(typed) {
+			return fmt.Errorf("%s: does not match %q", path, pattern)
+		}
+	case float64:
+		if min, ok := node["minimum"].(float64); ok && typed < min {
+			return fmt.Errorf("%s: %v is less than %v", path, typed, min)
+		}
+		if max, ok := node["maximum"].(float64); ok && typed > max {
+			return fmt.Errorf("%s: %v is more than %v", path, typed, max)
+		}
+	}
+
+	if allOf, ok := node["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			if err := s.validateChild(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := node["anyOf"].([]interface{}); ok && s.countMatches(anyOf, value, path) == 0 {
+		return fmt.Errorf("%s: matches none of the schemas in anyOf", path)
+	}
+	if oneOf, ok := node["oneOf"].([]interface{}); ok && s.countMatches(oneOf, value, path) != 1 {
+		return fmt.Errorf("%s: does not match exactly one of the schemas in oneOf", path)
+	}
+	return nil
+}
+
+func (s *schema) validateObject(node map[string]interface{}, object map[string]interface{}, path string) error {
+	if required, ok := node["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, present := object[key]; !present {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+	}
+
+	properties, _ := node["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		childPath := path + "." + key
+		if property, ok := properties[key]; ok {
+			if err := s.validateChild(property, object[key], childPath); err != nil {
+				return err
+			}
+		} else if additional, ok := node["additionalProperties"]; ok {
+			if allowed, isBool := additional.(bool); isBool && !allowed {
+				return fmt.Errorf("%s: property is not allowed", childPath)
+			}
+			if err := s.validateChild(additional, object[key], childPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateChild checks a value against a subschema, which may be an object or
+// a boolean
+func (s *schema) validateChild(sub interface{}, value interface{}, path string) error {
+	switch typed := sub.(type) {
+	case bool:
+		if !typed {
+			return fmt.Errorf("%s: no value is allowed", path)
+		}
+	case map[string]interface{}:
+		return s.validateNode(typed, value, path)
+	}
+	return nil
+}
+
+func (s *schema) countMatches(subs []interface{}, value interface{}, path string) int {
+	matches := 0
+	for _, sub := range subs {
+		if s.validateChild(sub, value, path) == nil {
+			matches++
+		}
+	}
+	return matches
+}
+
+// matchesType reports whether the value is of the type, or one of the types,
+// given by a schema's type keyword
+func matchesType(types interface{}, value interface{}) bool {
+	names := []interface{}{types}
+	if list, ok := types.([]interface{}); ok {
+		names = list
+	}
+	actual := jsonType(value)
+	for _, name := range names {
+		if name == actual || name == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if typed == math.Trunc(typed) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
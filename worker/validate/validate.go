@@ -0,0 +1,197 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+	"github.com/venicegeo/pzsvc-exec/worker/log"
+)
+
+// Output types that a rule may require
+const (
+	typeGeoJSON = "geojson"
+	typeGeoTIFF = "geotiff"
+	typeJSON    = "json"
+)
+
+// Outputs checks the given output files against the output rules in the
+// worker's config, and returns an error for each way in which a file breaks
+// a rule that applies to it
+func Outputs(cfg config.WorkerConfig, files []string) []error {
+	errs := []error{}
+	schemas := map[string]*schema{}
+	for _, rule := range cfg.PzSEConfig.OutputRules {
+		if rule.JSONSchema != "" && schemas[rule.JSONSchema] == nil {
+			loaded, err := loadSchema(rule.JSONSchema)
+			if err != nil {
+				return []error{fmt.Errorf("output rule for `%s` has a bad JSON Schema: %v", rule.Pattern, err)}
+			}
+			schemas[rule.JSONSchema] = loaded
+		}
+	}
+
+	for _, file := range files {
+		for _, rule := range cfg.PzSEConfig.OutputRules {
+			applies, err := ruleApplies(rule, file)
+			if err != nil {
+				return []error{err}
+			}
+			if !applies {
+				continue
+			}
+			if err := checkFile(rule, schemas[rule.JSONSchema], file); err != nil {
+				workerlog.SimpleErr(cfg, "output "+file+" failed validation: ", err)
+				errs = append(errs, fmt.Errorf("output `%s` failed validation: %v", file, err))
+			}
+		}
+	}
+	return errs
+}
+
+func ruleApplies(rule pzsvc.OutputRule, file string) (bool, error) {
+	if rule.Pattern == "" {
+		return true, nil
+	}
+	matched, err := filepath.Match(rule.Pattern, filepath.Base(file))
+	if err != nil {
+		return false, fmt.Errorf("output rule has a bad pattern `%s`: %v", rule.Pattern, err)
+	}
+	return matched, nil
+}
+
+// checkFile checks a file against one rule.  The syntax and type checks
+// stream the file, but checking it against a JSON Schema reads and decodes
+// the whole of it into memory, so rules with a schema should set MaxBytes.
+func checkFile(rule pzsvc.OutputRule, ruleSchema *schema, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if rule.MaxBytes > 0 && info.Size() > rule.MaxBytes {
+		return fmt.Errorf("file holds %d bytes, more than the limit of %d", info.Size(), rule.MaxBytes)
+	}
+
+	switch rule.Type {
+	case "":
+	case typeGeoTIFF:
+		if _, err := pzsvc.GeoTIFFSpatMeta(file); err != nil {
+			return fmt.Errorf("not a valid GeoTIFF: %v", err)
+		}
+	case typeGeoJSON:
+		topType, err := checkJSONSyntax(file)
+		if err != nil {
+			return fmt.Errorf("not valid JSON: %v", err)
+		}
+		if topType != "FeatureCollection" && topType != "Feature" {
+			return fmt.Errorf("GeoJSON must be a FeatureCollection or Feature, not %q", topType)
+		}
+	case typeJSON:
+		if _, err := checkJSONSyntax(file); err != nil {
+			return fmt.Errorf("not valid JSON: %v", err)
+		}
+	default:
+		return fmt.Errorf("output rule has unknown type `%s`", rule.Type)
+	}
+
+	if ruleSchema != nil {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		var document interface{}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return fmt.Errorf("not valid JSON: %v", err)
+		}
+		if err := ruleSchema.validate(document, "$"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkJSONSyntax reads through a JSON document a token at a time, so that
+// large documents can be checked without holding them in memory.  Returns the
+// "type" member of the document, if it is an object with one.
+func checkJSONSyntax(r io.Reader) (string, error) {
+	dec := json.NewDecoder(r)
+	depth := 0
+	topIsObject := false
+	atKey := false
+	key := ""
+	topType := ""
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			// A complete document returns below, before reaching the end
+			if depth > 0 {
+				return "", errors.New("the document ends before it is complete")
+			}
+			return "", errors.New("the document is empty")
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if delim, ok := token.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				if depth == 0 && delim == '{' {
+					topIsObject = true
+				}
+				depth++
+				if depth == 1 {
+					atKey = topIsObject
+				}
+			case '}', ']':
+				depth--
+				if depth == 1 {
+					atKey = topIsObject
+				}
+			}
+		} else if depth == 1 && topIsObject {
+			if atKey {
+				key, _ = token.(string)
+			} else if key == "type" {
+				topType, _ = token.(string)
+			}
+			atKey = !atKey
+		}
+
+		if depth == 0 {
+			// The document is complete; nothing may follow it
+			if _, err := dec.Token(); err != io.EOF {
+				return "", errors.New("unexpected data after the end of the document")
+			}
+			return topType, nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+)
+
+// writeTemp writes the given content to a file in dir, and returns its path
+func writeTemp(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckJSONSyntax(t *testing.T) {
+	testCases := []struct {
+		doc      string
+		topType  string
+		expError bool
+	}{
+		{`{"type":"FeatureCollection","features":[]}`, "FeatureCollection", false},
+		{`{"type":"Feature","geometry":null,"properties":{}}`, "Feature", false},
+		{`{"bbox":[[1,2],[3,[4]]],"type":"Feature"}`, "Feature", false},
+		{`{"properties":{"type":"Point"},"type":"Feature"}`, "Feature", false},
+		{`[{"type":"Feature"}]`, "", false},
+		{`"text"`, "", false},
+		{`{"type":"Feature"} {}`, "", true},
+		{`{"type":"Feature"} x`, "", true},
+		{`{"type":"Feature","features":[`, "", true},
+		{`{"type":`, "", true},
+		{``, "", true},
+		{`{"type" "Feature"}`, "", true},
+	}
+	for _, tc := range testCases {
+		topType, err := checkJSONSyntax(strings.NewReader(tc.doc))
+		if (err != nil) != tc.expError {
+			t.Errorf(`TestCheckJSONSyntax: %s: unexpected error result: %v`, tc.doc, err)
+		}
+		if err == nil && topType != tc.topType {
+			t.Errorf(`TestCheckJSONSyntax: %s: type %q, expected %q.`, tc.doc, topType, tc.topType)
+		}
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collection := writeTemp(t, dir, "collection.geojson", `{"type":"FeatureCollection","features":[]}`)
+	feature := writeTemp(t, dir, "feature.geojson", `{"type":"Feature","geometry":null,"properties":{}}`)
+	point := writeTemp(t, dir, "point.geojson", `{"type":"Point","coordinates":[1,2]}`)
+	trailing := writeTemp(t, dir, "trailing.json", `{"a":1}{"b":2}`)
+
+	testCases := []struct {
+		rule     pzsvc.OutputRule
+		path     string
+		expError bool
+	}{
+		{pzsvc.OutputRule{Type: "geojson"}, collection, false},
+		{pzsvc.OutputRule{Type: "geojson"}, feature, false},
+		{pzsvc.OutputRule{Type: "geojson"}, point, true},
+		{pzsvc.OutputRule{Type: "json"}, point, false},
+		{pzsvc.OutputRule{Type: "json"}, trailing, true},
+		{pzsvc.OutputRule{Type: "geotiff"}, point, true},
+		{pzsvc.OutputRule{Type: "shapefile"}, point, true},
+		{pzsvc.OutputRule{MaxBytes: 10}, point, true},
+		{pzsvc.OutputRule{MaxBytes: 100}, point, false},
+	}
+	for _, tc := range testCases {
+		err := checkFile(tc.rule, nil, tc.path)
+		if (err != nil) != tc.expError {
+			t.Errorf(`TestCheckFile: %+v on %s: unexpected error result: %v`, tc.rule, filepath.Base(tc.path), err)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	schemaPath := writeTemp(t, dir, "schema.json", `{
+		"type": "object",
+		"required": ["name"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "pattern": "^[a-z]+$"},
+			"count": {"type": "integer", "minimum": 0},
+			"value": {"oneOf": [{"type": "string"}, {"type": "number", "maximum": 10}]},
+			"tags": {"type": "array", "maxItems": 2, "items": {"anyOf": [{"const": "a"}, {"const": "b"}]}}
+		}
+	}`)
+	ruleSchema, err := loadSchema(schemaPath)
+	if err != nil {
+		t.Fatal(`TestSchemaValidate: could not load schema: ` + err.Error())
+	}
+
+	testCases := []struct {
+		doc      string
+		expError bool
+	}{
+		{`{"name":"abc"}`, false},
+		{`{"name":"abc","count":3,"value":"x","tags":["a","b"]}`, false},
+		{`{"name":"abc","value":5}`, false},
+		{`{"count":3}`, true},
+		{`{"name":"ABC"}`, true},
+		{`{"name":"abc","count":-1}`, true},
+		{`{"name":"abc","count":1.5}`, true},
+		{`{"name":"abc","value":50}`, true},
+		{`{"name":"abc","value":true}`, true},
+		{`{"name":"abc","tags":["c"]}`, true},
+		{`{"name":"abc","tags":["a","a","a"]}`, true},
+		{`{"name":"abc","extra":1}`, true},
+		{`["abc"]`, true},
+	}
+	for _, tc := range testCases {
+		path := writeTemp(t, dir, "doc.json", tc.doc)
+		err := checkFile(pzsvc.OutputRule{Type: "json"}, ruleSchema, path)
+		if (err != nil) != tc.expError {
+			t.Errorf(`TestSchemaValidate: %s: unexpected error result: %v`, tc.doc, err)
+		}
+	}
+
+	refPath := writeTemp(t, dir, "ref.json", `{"properties":{"a":{"$ref":"#/definitions/a"}}}`)
+	if _, err := loadSchema(refPath); err == nil {
+		t.Error(`TestSchemaValidate: schema using $ref was not refused.`)
+	}
+	badPattern := writeTemp(t, dir, "pattern.json", `{"pattern":"("}`)
+	if _, err := loadSchema(badPattern); err == nil {
+		t.Error(`TestSchemaValidate: schema with a bad pattern was not refused.`)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+	"github.com/venicegeo/pzsvc-exec/worker/log"
+)
+
+const defaultCancelPeriod = 30 * time.Second
+
+// watchForCancel returns a channel that is closed if the job is cancelled,
+// along with a function to stop watching.  A job is cancelled if Piazza
+// reports it so, which is checked periodically, or if the worker is sent
+// SIGTERM, as CF does when the dispatcher terminates its task.
+func watchForCancel(cfg config.WorkerConfig) (<-chan struct{}, func()) {
+	cancel := make(chan struct{})
+	stop := make(chan struct{})
+	var once sync.Once
+	doCancel := func(reason string) {
+		once.Do(func() {
+			workerlog.Warn(cfg, "Job cancelled: "+reason)
+			close(cancel)
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+
+	period := defaultCancelPeriod
+	if cfg.PzSEConfig.CancelPeriod > 0 {
+		period = time.Duration(cfg.PzSEConfig.CancelPeriod) * time.Second
+	}
+	checkPiazza := cfg.PzSEConfig.CancelPeriod >= 0
+
+	// The checks are made outside of the job's trace, and with their own copy
+	// of the session, which the job's phases go on to change
+	s := *cfg.Session
+	s.Span = nil
+
+	go func() {
+		defer signal.Stop(signals)
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-signals:
+				doCancel("received SIGTERM")
+			case <-ticker.C:
+				if !checkPiazza {
+					continue
+				}
+				status, err := pzsvc.GetJobStatus(s, cfg.JobID)
+				if err != nil {
+					err.Log(s, "Could not check job status for cancellation")
+					continue
+				}
+				if pzsvc.PiazzaStatus(status.Status) == pzsvc.PiazzaStatusCancelled {
+					doCancel("Piazza reports the job cancelled")
+				}
+			}
+		}
+	}()
+
+	var stopOnce sync.Once
+	return cancel, func() { stopOnce.Do(func() { close(stop) }) }
+}
+
+// isCancelled returns true if the given cancel channel has closed
+func isCancelled(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
+}
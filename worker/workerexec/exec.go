@@ -16,8 +16,11 @@ package workerexec
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/venicegeo/pzsvc-exec/pzsvc"
 	"github.com/venicegeo/pzsvc-exec/worker/config"
@@ -33,70 +36,230 @@ func WorkerExec(cfg config.WorkerConfig) (err error) {
 		OutFiles:   map[string]string{},
 		HTTPStatus: http.StatusOK,
 	}
+	metrics := jobMetrics{}
+	defer func() { metrics.report(cfg) }()
+
+	rootSpan := pzsvc.StartSpanFromTraceParent(cfg.TraceParent, "worker job")
+	rootSpan.SetAttribute("job.id", cfg.JobID)
+	rootSpan.SetAttribute("service.id", cfg.PiazzaServiceID)
+	rootSpan.SetAttribute("user.id", cfg.UserID)
+	defer func() {
+		rootSpan.SetError(err)
+		rootSpan.End()
+	}()
+
+	cancel, stopWatching := watchForCancel(cfg)
+	defer stopWatching()
 
 	workerlog.Info(cfg, "Fetching inputs")
-	err = input.FetchInputs(cfg, cfg.Inputs)
+	span := startPhase(cfg, rootSpan, "download inputs")
+	downloadStart := time.Now()
+	metrics.DownloadBytes, err = input.FetchInputs(cfg, cfg.Inputs)
+	metrics.DownloadTime = time.Since(downloadStart)
+	endPhase(span, err)
 	if err != nil {
 		workerlog.SimpleErr(cfg, "Failed to fetch inputs", err)
 		outData.AddErrors(err)
 		outData.HTTPStatus = http.StatusInternalServerError
-		return sendPiazzaJobOutput(cfg, outData)
+		return sendPiazzaJobOutput(cfg, rootSpan, pzsvc.PiazzaStatusError, outData)
 	}
 	outData.InFiles = cfg.InputsAsMap()
 	workerlog.Info(cfg, "Inputs fetched")
+	if isCancelled(cancel) {
+		return sendPiazzaJobCancelled(cfg, rootSpan)
+	}
 
 	workerlog.Info(cfg, "Running version command")
-	versionCmdOutput := runCommand(cfg, cfg.PzSEConfig.VersionCmd)
+	span = startPhase(cfg, rootSpan, "version command")
+	versionCmdOutput := runCommand(cfg, cfg.PzSEConfig.VersionCmd, nil, nil)
+	endPhase(span, versionCmdOutput.Error)
 	if versionCmdOutput.Error != nil {
 		workerlog.SimpleErr(cfg, "Failed to get algorithm version", versionCmdOutput.Error)
 		outData.AddErrors(versionCmdOutput.Error)
 		outData.HTTPStatus = http.StatusInternalServerError
 		outData.ProgStdErr = string(versionCmdOutput.Stderr)
-		return sendPiazzaJobOutput(cfg, outData)
+		return sendPiazzaJobOutput(cfg, rootSpan, pzsvc.PiazzaStatusError, outData)
 	}
 	version := strings.TrimSpace(string(versionCmdOutput.Stdout))
 	workerlog.Info(cfg, "Retrieved algorithm version: "+version)
 
 	fullCommand := strings.Join([]string{cfg.PzSEConfig.CliCmd, cfg.CLICommandExtra}, " ")
-	workerlog.Info(cfg, "Running algorithm command: "+fullCommand)
-	algCmdOutput := runCommand(cfg, fullCommand)
+	policy := newRetryPolicy(cfg)
+	var algCmdOutput commandOutput
+	var outcome exitOutcome
+	var outputsBefore ingest.OutputSnapshot
+	cancelled := false
+	algStart := time.Now()
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			if !policy.waitToRetry(cfg, attempt, cancel) {
+				cancelled = true
+				break
+			}
+			if err = ingest.RemoveOutputs(cfg, outputsBefore); err != nil {
+				workerlog.SimpleErr(cfg, "Failed to remove outputs of failed attempt", err)
+				outData.AddErrors(err)
+				outData.HTTPStatus = http.StatusInternalServerError
+				return sendPiazzaJobOutput(cfg, rootSpan, pzsvc.PiazzaStatusError, outData)
+			}
+			if policy.redownloadInputs {
+				workerlog.Info(cfg, "Fetching inputs again before retry")
+				span = startPhase(cfg, rootSpan, "download inputs")
+				downloadStart := time.Now()
+				var downloadBytes int64
+				err = input.RemoveInputs(cfg.Inputs)
+				if err == nil {
+					downloadBytes, err = input.FetchInputs(cfg, cfg.Inputs)
+				}
+				metrics.DownloadBytes += downloadBytes
+				metrics.DownloadTime += time.Since(downloadStart)
+				endPhase(span, err)
+				if err != nil {
+					workerlog.SimpleErr(cfg, "Failed to fetch inputs for retry", err)
+					outData.AddErrors(err)
+					outData.HTTPStatus = http.StatusInternalServerError
+					return sendPiazzaJobOutput(cfg, rootSpan, pzsvc.PiazzaStatusError, outData)
+				}
+			}
+		}
+
+		if policy.maxAttempts > 1 {
+			if outputsBefore, err = ingest.SnapshotOutputs(cfg); err != nil {
+				workerlog.SimpleErr(cfg, "Failed to list outputs before running algorithm command", err)
+				outData.AddErrors(err)
+				outData.HTTPStatus = http.StatusInternalServerError
+				return sendPiazzaJobOutput(cfg, rootSpan, pzsvc.PiazzaStatusError, outData)
+			}
+		}
+
+		workerlog.Info(cfg, "Running algorithm command: "+fullCommand)
+		metrics.Attempts = attempt
+		span = startPhase(cfg, rootSpan, "algorithm")
+		span.SetAttribute("attempt", strconv.Itoa(attempt))
+		progress := newProgressReporter(cfg, time.Now())
+		algCmdOutput = runCommand(cfg, fullCommand, progress.OnLine, cancel)
+		progress.Stop()
+		span.SetAttribute("process.exit_code", strconv.Itoa(algCmdOutput.ExitCode))
+		endPhase(span, algCmdOutput.Error)
+
+		outcome = classifyExit(cfg, algCmdOutput)
+		retryable := policy.retryable(outcome, algCmdOutput)
+		if policy.maxAttempts > 1 {
+			outData.Attempts = append(outData.Attempts, newAttemptOutput(attempt, algCmdOutput, retryable))
+		}
+		if !retryable || attempt >= policy.maxAttempts {
+			break
+		}
+		workerlog.Warn(cfg, "Algorithm command failed with a retryable error: "+algCmdOutput.Error.Error())
+	}
+	metrics.AlgorithmTime = time.Since(algStart)
+	metrics.ExitCode = algCmdOutput.ExitCode
 	outData.ProgStdOut = string(algCmdOutput.Stdout)
 	outData.ProgStdErr = string(algCmdOutput.Stderr)
-	if algCmdOutput.Error != nil {
-		workerlog.SimpleErr(cfg, "Failed running algorithm command", algCmdOutput.Error)
-		outData.AddErrors(algCmdOutput.Error)
-		outData.HTTPStatus = http.StatusInternalServerError
-		return sendPiazzaJobOutput(cfg, outData)
+	outData.ExitCode = &algCmdOutput.ExitCode
+	outData.Signal = algCmdOutput.Signal
+	// A cancel that arrives once the command has exited does not throw
+	// away its run
+	if cancelled || algCmdOutput.Cancelled {
+		workerlog.Info(cfg, "Skipping ingest of cancelled job")
+		return sendPiazzaJobCancelled(cfg, rootSpan)
+	}
+	jobStatus := pzsvc.PiazzaStatusSuccess
+	switch {
+	case outcome.failed():
+		algErr := algCmdOutput.Error
+		if algErr == nil {
+			algErr = fmt.Errorf("algorithm command exited with code %d", algCmdOutput.ExitCode)
+		}
+		workerlog.SimpleErr(cfg, "Failed running algorithm command ("+string(outcome)+")", algErr)
+		outData.AddErrors(algErr)
+		jobStatus, outData.HTTPStatus = outcome.piazzaStatus()
+		if !cfg.PzSEConfig.IngestPartial {
+			return sendPiazzaJobOutput(cfg, rootSpan, jobStatus, outData)
+		}
+		workerlog.Info(cfg, "Ingesting whatever outputs the failed algorithm command left")
+	case outcome == outcomeWarning:
+		warning := fmt.Sprintf("algorithm command exited with code %d", algCmdOutput.ExitCode)
+		workerlog.Warn(cfg, "Algorithm command succeeded with warnings: "+warning)
+		outData.Warnings = append(outData.Warnings, warning)
+	default:
+		workerlog.Info(cfg, "Algorithm command successful")
 	}
-	workerlog.Info(cfg, "Algorithm command successful")
 
 	workerlog.Info(cfg, "Ingesting output files to Piazza")
-	ingestOutput := ingest.OutputFilesToPiazza(cfg, fullCommand, version)
+	span = startPhase(cfg, rootSpan, "ingest outputs")
+	ingestStart := time.Now()
+	ingestOutput := ingest.OutputFilesToPiazza(cfg, ingest.Provenance{
+		Command:   fullCommand,
+		Version:   version,
+		StartTime: algStart,
+		Runtime:   metrics.AlgorithmTime,
+		ExitCode:  algCmdOutput.ExitCode,
+		Attempts:  metrics.Attempts,
+	}, outcome.failed())
+	metrics.IngestTime = time.Since(ingestStart)
+	endPhase(span, ingestOutput.CombinedError)
+	outData.OutFiles = ingestOutput.DataIDs
+	outData.ProvDataID = ingestOutput.ProvenanceID
+	outData.Deployed = ingestOutput.Deployments
 	if ingestOutput.CombinedError != nil {
 		workerlog.SimpleErr(cfg, "Received combined error from ingestion", ingestOutput.CombinedError)
 		outData.AddErrors(ingestOutput.Errors...)
-		outData.HTTPStatus = http.StatusInternalServerError
-		return sendPiazzaJobOutput(cfg, outData)
+		if !outcome.failed() {
+			jobStatus = pzsvc.PiazzaStatusError
+			outData.HTTPStatus = http.StatusInternalServerError
+		}
+		return sendPiazzaJobOutput(cfg, rootSpan, jobStatus, outData)
 	}
-	outData.OutFiles = ingestOutput.DataIDs
 	workerlog.Info(cfg, "Ingest successful")
+	if outcome.failed() {
+		return sendPiazzaJobOutput(cfg, rootSpan, jobStatus, outData)
+	}
 
 	workerlog.Info(cfg, "Setting successful Piazza job")
-	err = sendPiazzaJobOutput(cfg, outData)
+	err = sendPiazzaJobOutput(cfg, rootSpan, jobStatus, outData)
 	workerlog.Info(cfg, "Piazza job status updated, worker execution finished")
 
 	return
 }
 
-func sendPiazzaJobOutput(cfg config.WorkerConfig, outData workerOutputData) error {
+// sendPiazzaJobCancelled tells Piazza that the job was cancelled before it
+// could finish.  A cancelled job is not an error on the worker's part.
+func sendPiazzaJobCancelled(cfg config.WorkerConfig, rootSpan *pzsvc.Span) error {
+	span := startPhase(cfg, rootSpan, "send result")
+	defer span.End()
+	rootSpan.SetAttribute("job.status", string(pzsvc.PiazzaStatusCancelled))
+	pzsvcErr := pzsvc.SendExecResultError(*cfg.Session, cfg.PiazzaBaseURL, cfg.PiazzaServiceID, cfg.JobID, pzsvc.PiazzaStatusCancelled, "Job cancelled")
+	if pzsvcErr != nil {
+		// Piazza may well refuse updates to a job it has already cancelled
+		pzsvcErr.Log(*cfg.Session, "failed to send cancelled status")
+	}
+	return nil
+}
+
+// startPhase begins a span for one phase of the job, and makes it the parent
+// of any Piazza calls made during that phase.  Log entries made during the
+// phase are marked with its name.
+func startPhase(cfg config.WorkerConfig, rootSpan *pzsvc.Span, name string) *pzsvc.Span {
+	span := pzsvc.StartSpan(rootSpan, name)
+	*cfg.Session = cfg.Session.WithField("phase", name)
+	cfg.Session.Span = span
+	return span
+}
+
+// endPhase ends the span for a phase of the job, recording its error if any
+func endPhase(span *pzsvc.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
+
+// sendPiazzaJobOutput sends the job's result to Piazza, with the given status
+func sendPiazzaJobOutput(cfg config.WorkerConfig, rootSpan *pzsvc.Span, jobStatus pzsvc.PiazzaStatus, outData workerOutputData) (err error) {
+	span := startPhase(cfg, rootSpan, "send result")
+	defer func() { endPhase(span, err) }()
 	serializedOutData, _ := json.Marshal(outData)
 	workerlog.Info(cfg, "sending serialized output: "+string(serializedOutData))
-	var jobStatus pzsvc.PiazzaStatus
-	if len(outData.Errors) == 0 {
-		jobStatus = pzsvc.PiazzaStatusSuccess
-	} else {
-		jobStatus = pzsvc.PiazzaStatusError
-	}
+	rootSpan.SetAttribute("job.status", string(jobStatus))
 	pzsvcErr := pzsvc.SendExecResultData(*cfg.Session, cfg.PiazzaBaseURL, cfg.PiazzaServiceID, cfg.JobID, jobStatus, serializedOutData)
 	if pzsvcErr != nil {
 		return pzsvcErr.Log(*cfg.Session, "failed to send result data")
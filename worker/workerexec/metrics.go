@@ -0,0 +1,77 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+	"github.com/venicegeo/pzsvc-exec/worker/log"
+)
+
+var (
+	downloadBytesGauge = pzsvc.NewGauge("worker_download_bytes",
+		"Bytes of input downloaded for the job.", "service")
+	downloadSecondsGauge = pzsvc.NewGauge("worker_download_seconds",
+		"Time spent downloading input for the job.", "service")
+	algorithmSecondsGauge = pzsvc.NewGauge("worker_algorithm_seconds",
+		"Time spent running the algorithm for the job.", "service")
+	ingestSecondsGauge = pzsvc.NewGauge("worker_ingest_seconds",
+		"Time spent ingesting the job's output to Piazza.", "service")
+	exitCodeGauge = pzsvc.NewGauge("worker_exit_code",
+		"Exit code of the job's algorithm command, or -1 if it did not exit normally.", "service")
+	attemptsGauge = pzsvc.NewGauge("worker_algorithm_attempts",
+		"Number of times the job's algorithm command was run.", "service")
+)
+
+// jobMetrics collects the summary measurements for a single worker job
+type jobMetrics struct {
+	DownloadBytes int64
+	DownloadTime  time.Duration
+	AlgorithmTime time.Duration
+	IngestTime    time.Duration
+	ExitCode      int
+	Attempts      int
+}
+
+// report records the job's measurements as metrics, logs a summary line, and
+// sends the metrics to the configured pushgateway, if there is one
+func (m jobMetrics) report(cfg config.WorkerConfig) {
+	svc := cfg.PiazzaServiceID
+	downloadBytesGauge.Set(float64(m.DownloadBytes), svc)
+	downloadSecondsGauge.Set(m.DownloadTime.Seconds(), svc)
+	algorithmSecondsGauge.Set(m.AlgorithmTime.Seconds(), svc)
+	ingestSecondsGauge.Set(m.IngestTime.Seconds(), svc)
+	attemptsGauge.Set(float64(m.Attempts), svc)
+
+	summary := fmt.Sprintf("job metrics: download_bytes=%d download_seconds=%.3f algorithm_seconds=%.3f ingest_seconds=%.3f attempts=%d",
+		m.DownloadBytes, m.DownloadTime.Seconds(), m.AlgorithmTime.Seconds(), m.IngestTime.Seconds(), m.Attempts)
+	// There is no exit code to report if the algorithm never ran
+	if m.Attempts > 0 {
+		exitCodeGauge.Set(float64(m.ExitCode), svc)
+		summary += fmt.Sprintf(" exit_code=%d", m.ExitCode)
+	}
+	workerlog.Info(cfg, summary)
+
+	// Grouped by job as well as service, since workers for the same service
+	// run at the same time, and would otherwise overwrite each other's push
+	if cfg.PzSEConfig.MetricsPushURL != "" {
+		if err := pzsvc.PushMetrics(cfg.PzSEConfig.MetricsPushURL, "pzsvc-worker", "service", svc, "instance", cfg.JobID); err != nil {
+			err.Log(*cfg.Session, "failed to push job metrics")
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+)
+
+func TestJobMetricsPush(t *testing.T) {
+	paths := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.EscapedPath()
+	}))
+	defer server.Close()
+	pzsvc.SetHTTPClient(&http.Client{})
+	defer pzsvc.SetHTTPClient(nil)
+
+	// Two jobs for the same service must push to different groups
+	for _, jobID := range []string{"job1", "job2"} {
+		cfg := config.WorkerConfig{Session: &pzsvc.Session{AppName: "test"}, PiazzaServiceID: "svc", JobID: jobID}
+		cfg.PzSEConfig.MetricsPushURL = server.URL
+		jobMetrics{Attempts: 1}.report(cfg)
+	}
+	for _, expected := range []string{"/metrics/job/pzsvc-worker/service/svc/instance/job1", "/metrics/job/pzsvc-worker/service/svc/instance/job2"} {
+		if path := <-paths; path != expected {
+			t.Errorf(`TestJobMetricsPush: pushed to %s, expected %s.`, path, expected)
+		}
+	}
+}
@@ -14,15 +14,35 @@
 
 package workerexec
 
+import "github.com/venicegeo/pzsvc-exec/pzsvc"
+
 // workerOutputData populates and provides the format for pzsvc-exec's output
 // Reimplementation of pzse.OutStruct
 type workerOutputData struct {
 	InFiles    map[string]string `json:"InFiles,omitempty"`
 	OutFiles   map[string]string `json:"OutFiles,omitempty"`
+	ProvDataID string            `json:"ProvenanceID,omitempty"`
+	Deployed   []pzsvc.DeplStrct `json:"Deployments,omitempty"`
 	ProgStdOut string            `json:"ProgStdOut,omitempty"`
 	ProgStdErr string            `json:"ProgStdErr,omitempty"`
 	Errors     []string          `json:"Errors,omitempty"`
+	Warnings   []string          `json:"Warnings,omitempty"`
 	HTTPStatus int               `json:"HTTPStatus,omitempty"`
+	ExitCode   *int              `json:"ExitCode,omitempty"`
+	Signal     string            `json:"Signal,omitempty"`
+	Attempts   []attemptOutput   `json:"Attempts,omitempty"`
+}
+
+// attemptOutput is the result of one run of the algorithm command, recorded
+// when the retry policy allows more than one
+type attemptOutput struct {
+	Attempt    int    `json:"Attempt"`
+	ExitCode   int    `json:"ExitCode"`
+	Signal     string `json:"Signal,omitempty"`
+	ProgStdOut string `json:"ProgStdOut,omitempty"`
+	ProgStdErr string `json:"ProgStdErr,omitempty"`
+	Error      string `json:"Error,omitempty"`
+	Retryable  bool   `json:"Retryable"`
 }
 
 func (d *workerOutputData) AddErrors(errors ...error) {
@@ -0,0 +1,70 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+	"github.com/venicegeo/pzsvc-exec/worker/log"
+)
+
+// exitOutcome is what an algorithm's exit means for its job
+type exitOutcome string
+
+const (
+	outcomeSuccess       exitOutcome = "success"
+	outcomeWarning       exitOutcome = "warning"
+	outcomeInputError    exitOutcome = "inputError"
+	outcomeInternalError exitOutcome = "internalError"
+)
+
+// failed reports whether the outcome fails the job
+func (o exitOutcome) failed() bool {
+	return o == outcomeInputError || o == outcomeInternalError
+}
+
+// piazzaStatus returns the Piazza job status and HTTP status for a failed
+// outcome
+func (o exitOutcome) piazzaStatus() (pzsvc.PiazzaStatus, int) {
+	if o == outcomeInputError {
+		return pzsvc.PiazzaStatusFail, http.StatusBadRequest
+	}
+	return pzsvc.PiazzaStatusError, http.StatusInternalServerError
+}
+
+// classifyExit decides the outcome of an algorithm run from its exit code,
+// through the ExitCodes map in the config.  Unmapped codes are a success if
+// zero, and an internal error otherwise.  A command that could not be run or
+// was killed by a signal is always an internal error.
+func classifyExit(cfg config.WorkerConfig, out commandOutput) exitOutcome {
+	if out.ExitCode < 0 {
+		return outcomeInternalError
+	}
+	if mapped, ok := cfg.PzSEConfig.ExitCodes[strconv.Itoa(out.ExitCode)]; ok {
+		switch outcome := exitOutcome(mapped); outcome {
+		case outcomeSuccess, outcomeWarning, outcomeInputError, outcomeInternalError:
+			return outcome
+		default:
+			workerlog.Warn(cfg, "Ignoring unknown outcome "+strconv.Quote(mapped)+" for exit code "+strconv.Itoa(out.ExitCode))
+		}
+	}
+	if out.ExitCode == 0 {
+		return outcomeSuccess
+	}
+	return outcomeInternalError
+}
@@ -0,0 +1,85 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+)
+
+func TestClassifyExit(t *testing.T) {
+	cfg := config.WorkerConfig{Session: &pzsvc.Session{AppName: "test"}}
+	cfg.PzSEConfig.ExitCodes = map[string]string{
+		"0": "warning",
+		"2": "inputError",
+		"3": "success",
+		"4": "internalError",
+		"5": "bogus",
+	}
+	testCases := []struct {
+		exitCode int
+		expected exitOutcome
+	}{
+		{0, outcomeWarning},
+		{2, outcomeInputError},
+		{3, outcomeSuccess},
+		{4, outcomeInternalError},
+		{5, outcomeInternalError},
+		{6, outcomeInternalError},
+		{-1, outcomeInternalError},
+	}
+	for _, tc := range testCases {
+		if outcome := classifyExit(cfg, commandOutput{ExitCode: tc.exitCode}); outcome != tc.expected {
+			t.Errorf(`TestClassifyExit: exit code %d gave %s, expected %s.`, tc.exitCode, outcome, tc.expected)
+		}
+	}
+
+	cfg.PzSEConfig.ExitCodes = nil
+	if outcome := classifyExit(cfg, commandOutput{ExitCode: 0}); outcome != outcomeSuccess {
+		t.Errorf(`TestClassifyExit: unmapped zero exit gave %s.`, outcome)
+	}
+	if outcome := classifyExit(cfg, commandOutput{ExitCode: 1}); outcome != outcomeInternalError {
+		t.Errorf(`TestClassifyExit: unmapped non-zero exit gave %s.`, outcome)
+	}
+}
+
+func TestExitOutcomePiazzaStatus(t *testing.T) {
+	testCases := []struct {
+		outcome    exitOutcome
+		failed     bool
+		status     pzsvc.PiazzaStatus
+		httpStatus int
+	}{
+		{outcomeSuccess, false, pzsvc.PiazzaStatusError, http.StatusInternalServerError},
+		{outcomeWarning, false, pzsvc.PiazzaStatusError, http.StatusInternalServerError},
+		{outcomeInputError, true, pzsvc.PiazzaStatusFail, http.StatusBadRequest},
+		{outcomeInternalError, true, pzsvc.PiazzaStatusError, http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		if tc.outcome.failed() != tc.failed {
+			t.Errorf(`TestExitOutcomePiazzaStatus: %s: failed() was %v.`, tc.outcome, !tc.failed)
+		}
+		if !tc.failed {
+			continue
+		}
+		status, httpStatus := tc.outcome.piazzaStatus()
+		if status != tc.status || httpStatus != tc.httpStatus {
+			t.Errorf(`TestExitOutcomePiazzaStatus: %s: gave %s/%d, expected %s/%d.`, tc.outcome, status, httpStatus, tc.status, tc.httpStatus)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package workerexec
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing on Windows, which has no process groups
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup kills the command itself, as Windows cannot signal a
+// process group
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return cmd.Process.Kill()
+}
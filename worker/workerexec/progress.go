@@ -0,0 +1,122 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+	"github.com/venicegeo/pzsvc-exec/worker/log"
+)
+
+const defaultProgressPeriod = 10 * time.Second
+
+// progressRegexp matches the lines an algorithm writes to its stdout to
+// report progress, such as "PROGRESS: 45%"
+var progressRegexp = regexp.MustCompile(`(?i)^\s*PROGRESS:\s*(\d{1,3})(?:\.\d*)?\s*%`)
+
+// progressReporter relays an algorithm's progress to Piazza.  Updates are sent
+// from a routine of their own, so that a slow Piazza never holds up the
+// algorithm, and no more often than once per period.  Updates that arrive in
+// between replace one another, so that only the latest is sent.
+type progressReporter struct {
+	cfg    config.WorkerConfig
+	start  time.Time
+	period time.Duration
+	latest chan int
+	stop   chan struct{}
+	done   sync.WaitGroup
+}
+
+func newProgressReporter(cfg config.WorkerConfig, start time.Time) *progressReporter {
+	period := defaultProgressPeriod
+	if cfg.PzSEConfig.ProgressPeriod > 0 {
+		period = time.Duration(cfg.PzSEConfig.ProgressPeriod) * time.Second
+	}
+	pr := &progressReporter{cfg: cfg, start: start, period: period, latest: make(chan int, 1), stop: make(chan struct{})}
+	pr.done.Add(1)
+	go pr.run()
+	return pr
+}
+
+// OnLine checks a line of the algorithm's output for a progress report
+func (pr *progressReporter) OnLine(line string) {
+	matches := progressRegexp.FindStringSubmatch(line)
+	if matches == nil {
+		return
+	}
+	percent, _ := strconv.Atoi(matches[1])
+	if percent > 100 {
+		percent = 100
+	}
+	for {
+		select {
+		case pr.latest <- percent:
+			return
+		default:
+			// Drop the update not yet sent, in favor of this one
+			select {
+			case <-pr.latest:
+			default:
+			}
+		}
+	}
+}
+
+// Stop sends no further updates, and waits for any being sent to finish
+func (pr *progressReporter) Stop() {
+	close(pr.stop)
+	pr.done.Wait()
+}
+
+func (pr *progressReporter) run() {
+	defer pr.done.Done()
+	lastSent := -1
+	for {
+		select {
+		case percent := <-pr.latest:
+			if percent == lastSent {
+				continue
+			}
+			pr.send(percent)
+			lastSent = percent
+		case <-pr.stop:
+			return
+		}
+		select {
+		case <-time.After(pr.period):
+		case <-pr.stop:
+			return
+		}
+	}
+}
+
+func (pr *progressReporter) send(percent int) {
+	spent := time.Since(pr.start)
+	progress := pzsvc.JobProg{PercentComplete: percent, TimeSpent: spent.Round(time.Second).String()}
+	if percent > 0 {
+		remaining := time.Duration(float64(spent) * float64(100-percent) / float64(percent))
+		progress.TimeRemaining = remaining.Round(time.Second).String()
+	}
+	workerlog.Info(pr.cfg, "Algorithm reports progress of "+strconv.Itoa(percent)+"%")
+	err := pzsvc.SendExecProgress(*pr.cfg.Session, pr.cfg.PiazzaBaseURL, pr.cfg.PiazzaServiceID, pr.cfg.JobID, progress)
+	if err != nil {
+		err.Log(*pr.cfg.Session, "failed to send job progress")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+)
+
+func TestProgressRegexp(t *testing.T) {
+	testCases := []struct {
+		line    string
+		percent string
+	}{
+		{"PROGRESS: 45%", "45"},
+		{"  progress:7 %", "7"},
+		{"Progress: 99.5%", "99"},
+		{"PROGRESS: 250%", "250"},
+		{"PROGRESS: 1000%", ""},
+		{"PROGRESS: 45", ""},
+		{"Done; PROGRESS: 45%", ""},
+		{"PROGRESS: -5%", ""},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		matches := progressRegexp.FindStringSubmatch(tc.line)
+		percent := ""
+		if matches != nil {
+			percent = matches[1]
+		}
+		if percent != tc.percent {
+			t.Errorf(`TestProgressRegexp: %q gave %q, expected %q.`, tc.line, percent, tc.percent)
+		}
+	}
+}
+
+func TestProgressReporter(t *testing.T) {
+	sent := make(chan int, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var update struct {
+			Progress pzsvc.JobProg `json:"progress"`
+		}
+		json.NewDecoder(r.Body).Decode(&update)
+		sent <- update.Progress.PercentComplete
+	}))
+	defer server.Close()
+	pzsvc.SetHTTPClient(&http.Client{})
+	defer pzsvc.SetHTTPClient(nil)
+
+	cfg := config.WorkerConfig{Session: &pzsvc.Session{AppName: "test"}, PiazzaBaseURL: server.URL, PiazzaServiceID: "svc", JobID: "job"}
+	pr := &progressReporter{cfg: cfg, start: time.Now(), period: 200 * time.Millisecond, latest: make(chan int, 1), stop: make(chan struct{})}
+	pr.done.Add(1)
+	go pr.run()
+
+	receive := func() int {
+		select {
+		case percent := <-sent:
+			return percent
+		case <-time.After(2 * time.Second):
+			return -1
+		}
+	}
+
+	pr.OnLine("working")
+	pr.OnLine("PROGRESS: 10%")
+	if percent := receive(); percent != 10 {
+		t.Errorf(`TestProgressReporter: first update was %d.`, percent)
+	}
+
+	// Updates within the period replace one another, and over 100 is capped
+	pr.OnLine("PROGRESS: 20%")
+	pr.OnLine("PROGRESS: 300%")
+	if percent := receive(); percent != 100 {
+		t.Errorf(`TestProgressReporter: throttled update was %d.`, percent)
+	}
+
+	// An unchanged percentage is not sent again
+	pr.OnLine("PROGRESS: 100%")
+	time.Sleep(3 * pr.period)
+	pr.Stop()
+	select {
+	case percent := <-sent:
+		t.Errorf(`TestProgressReporter: unexpected update %d.`, percent)
+	default:
+	}
+}
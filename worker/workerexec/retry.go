@@ -0,0 +1,120 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+	"github.com/venicegeo/pzsvc-exec/worker/log"
+)
+
+// retryPolicy decides whether a failed algorithm run should be tried again
+type retryPolicy struct {
+	maxAttempts      int
+	exitCodes        map[int]bool
+	stderrPatterns   []*regexp.Regexp
+	backoff          time.Duration
+	redownloadInputs bool
+}
+
+// newRetryPolicy builds the retry policy from the worker's config.  Invalid
+// stderr patterns are logged and ignored.
+func newRetryPolicy(cfg config.WorkerConfig) retryPolicy {
+	policy := retryPolicy{maxAttempts: 1, exitCodes: map[int]bool{}}
+	pzPolicy := cfg.PzSEConfig.RetryPolicy
+	if pzPolicy == nil {
+		return policy
+	}
+	if pzPolicy.MaxAttempts > 1 {
+		policy.maxAttempts = pzPolicy.MaxAttempts
+	}
+	for _, code := range pzPolicy.RetryExitCodes {
+		policy.exitCodes[code] = true
+	}
+	for _, pattern := range pzPolicy.RetryStderrPatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			workerlog.SimpleErr(cfg, "Ignoring invalid retry stderr pattern "+strconv.Quote(pattern), err)
+			continue
+		}
+		policy.stderrPatterns = append(policy.stderrPatterns, compiled)
+	}
+	policy.backoff = time.Duration(pzPolicy.BackoffSeconds) * time.Second
+	policy.redownloadInputs = pzPolicy.RedownloadInputs
+	return policy
+}
+
+// retryable reports whether the run, with the given outcome, may be retried
+// under the policy.  Only internal errors are retried: an input error would
+// only fail the same way again.
+func (p retryPolicy) retryable(outcome exitOutcome, out commandOutput) bool {
+	if outcome != outcomeInternalError || out.Cancelled {
+		return false
+	}
+	if len(p.exitCodes) == 0 && len(p.stderrPatterns) == 0 {
+		return true
+	}
+	if p.exitCodes[out.ExitCode] {
+		return true
+	}
+	for _, pattern := range p.stderrPatterns {
+		if pattern.Match(out.Stderr) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoffBefore returns how long to wait before the given attempt, counting
+// from 1
+func (p retryPolicy) backoffBefore(attempt int) time.Duration {
+	if attempt < 2 {
+		return 0
+	}
+	return p.backoff << uint(attempt-2)
+}
+
+// waitToRetry waits out the backoff before the given attempt.  Returns false
+// if the job was cancelled while waiting.
+func (p retryPolicy) waitToRetry(cfg config.WorkerConfig, attempt int, cancel <-chan struct{}) bool {
+	wait := p.backoffBefore(attempt)
+	workerlog.Info(cfg, "Retrying algorithm command in "+wait.String()+"; attempt "+strconv.Itoa(attempt)+" of "+strconv.Itoa(p.maxAttempts))
+	select {
+	case <-time.After(wait):
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
+// newAttemptOutput records the result of one run of the algorithm
+func newAttemptOutput(attempt int, out commandOutput, retryable bool) attemptOutput {
+	result := attemptOutput{
+		Attempt:    attempt,
+		ExitCode:   out.ExitCode,
+		Signal:     out.Signal,
+		ProgStdOut: string(out.Stdout),
+		ProgStdErr: string(out.Stderr),
+		Retryable:  retryable,
+	}
+	if out.Error != nil {
+		result.Error = pzsvc.Redact(out.Error.Error())
+	}
+	return result
+}
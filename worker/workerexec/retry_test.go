@@ -0,0 +1,102 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerexec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+)
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	cfg := config.WorkerConfig{Session: &pzsvc.Session{AppName: "test"}}
+	cfg.PzSEConfig.RetryPolicy = &pzsvc.RetryPolicy{
+		MaxAttempts:         3,
+		RetryExitCodes:      []int{75, 111},
+		RetryStderrPatterns: []string{"(?i)connection reset", "("},
+	}
+	policy := newRetryPolicy(cfg)
+	if policy.maxAttempts != 3 || len(policy.stderrPatterns) != 1 {
+		t.Fatalf(`TestRetryPolicyRetryable: bad policy %+v.`, policy)
+	}
+
+	failed := errors.New("exit status")
+	testCases := []struct {
+		name     string
+		outcome  exitOutcome
+		out      commandOutput
+		expected bool
+	}{
+		{"success", outcomeSuccess, commandOutput{ExitCode: 75}, false},
+		{"listed code", outcomeInternalError, commandOutput{ExitCode: 75, Error: failed}, true},
+		{"other listed code", outcomeInternalError, commandOutput{ExitCode: 111, Error: failed}, true},
+		{"unlisted code", outcomeInternalError, commandOutput{ExitCode: 1, Error: failed}, false},
+		{"stderr pattern", outcomeInternalError, commandOutput{ExitCode: 1, Stderr: []byte("read: Connection Reset by peer"), Error: failed}, true},
+		{"other stderr", outcomeInternalError, commandOutput{ExitCode: 1, Stderr: []byte("file not found"), Error: failed}, false},
+		{"cancelled", outcomeInternalError, commandOutput{ExitCode: 75, Cancelled: true, Error: failed}, false},
+		{"input error", outcomeInputError, commandOutput{ExitCode: 75, Error: failed}, false},
+		{"warning", outcomeWarning, commandOutput{ExitCode: 75, Error: failed}, false},
+	}
+	for _, tc := range testCases {
+		if retryable := policy.retryable(tc.outcome, tc.out); retryable != tc.expected {
+			t.Errorf(`TestRetryPolicyRetryable: %s gave %v.`, tc.name, retryable)
+		}
+	}
+
+	// With neither codes nor patterns, every failure is retryable
+	cfg.PzSEConfig.RetryPolicy = &pzsvc.RetryPolicy{MaxAttempts: 2}
+	policy = newRetryPolicy(cfg)
+	if !policy.retryable(outcomeInternalError, commandOutput{ExitCode: 1, Error: failed}) || policy.retryable(outcomeSuccess, commandOutput{}) {
+		t.Error(`TestRetryPolicyRetryable: policy without conditions gave the wrong answer.`)
+	}
+
+	cfg.PzSEConfig.RetryPolicy = nil
+	if policy = newRetryPolicy(cfg); policy.maxAttempts != 1 {
+		t.Errorf(`TestRetryPolicyRetryable: default policy allows %d attempts.`, policy.maxAttempts)
+	}
+}
+
+func TestRetryPolicyExitCodes(t *testing.T) {
+	// Exit codes mapped to outcomes, under a policy that retries every failure
+	cfg := config.WorkerConfig{Session: &pzsvc.Session{AppName: "test"}}
+	cfg.PzSEConfig.ExitCodes = map[string]string{"2": "inputError", "3": "warning", "4": "internalError"}
+	cfg.PzSEConfig.RetryPolicy = &pzsvc.RetryPolicy{MaxAttempts: 3}
+	policy := newRetryPolicy(cfg)
+
+	failed := errors.New("exit status")
+	expected := map[int]bool{0: false, 1: true, 2: false, 3: false, 4: true}
+	for code, retryable := range expected {
+		out := commandOutput{ExitCode: code}
+		if code != 0 {
+			out.Error = failed
+		}
+		if actual := policy.retryable(classifyExit(cfg, out), out); actual != retryable {
+			t.Errorf(`TestRetryPolicyExitCodes: exit code %d gave %v, expected %v.`, code, actual, retryable)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{backoff: 2 * time.Second}
+	expected := []time.Duration{0, 0, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for attempt, wait := range expected {
+		if actual := policy.backoffBefore(attempt); actual != wait {
+			t.Errorf(`TestRetryPolicyBackoff: attempt %d waits %v, expected %v.`, attempt, actual, wait)
+		}
+	}
+}
@@ -15,32 +15,109 @@
 package workerexec
 
 import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
 	"os/exec"
+	"syscall"
+	"time"
 
 	"github.com/venicegeo/pzsvc-exec/worker/config"
 	"github.com/venicegeo/pzsvc-exec/worker/log"
 )
 
+// killGracePeriod is how long a cancelled command is given to exit after
+// being asked to, before it is killed outright.  It is kept well short of the
+// 10 seconds that CF gives the worker itself after asking it to stop.
+const killGracePeriod = 5 * time.Second
+
 type commandOutput struct {
-	Stdout []byte
-	Stderr []byte
-	Error  error
+	Stdout    []byte
+	Stderr    []byte
+	ExitCode  int    // -1 if the command did not exit normally
+	Signal    string // The signal that killed the command, if any
+	Cancelled bool   // True if the command was terminated because of a cancel
+	Error     error
 }
 
-func runCommand(cfg config.WorkerConfig, command string) (out commandOutput) {
-	var err error
+// runCommand runs the given command through the shell.  If onLine is not nil,
+// it is called with each line of the command's stdout as the line is written.
+// If the cancel channel closes while the command runs, the command and every
+// process it has started are terminated.
+func runCommand(cfg config.WorkerConfig, command string, onLine func(line string), cancel <-chan struct{}) (out commandOutput) {
 	workerlog.Info(cfg, "runCommand: "+command)
 
+	var stdout, stderr bytes.Buffer
 	cmd := exec.Command("sh", "-c", command)
-	out.Stdout, out.Error = cmd.Output()
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	pipe, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err == nil {
+		finished := make(chan struct{})
+		stopped := make(chan struct{})
+		killed := false
+		go func() {
+			defer close(stopped)
+			select {
+			case <-cancel:
+				// A command that has already exited is left be
+				select {
+				case <-finished:
+					return
+				default:
+				}
+				killed = true
+				workerlog.Warn(cfg, "job cancelled; terminating command")
+				signalProcessGroup(cmd, syscall.SIGTERM)
+				select {
+				case <-time.After(killGracePeriod):
+					signalProcessGroup(cmd, syscall.SIGKILL)
+				case <-finished:
+				}
+			case <-finished:
+			}
+		}()
+
+		tee := io.TeeReader(pipe, &stdout)
+		scanner := bufio.NewScanner(tee)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if onLine != nil {
+				onLine(scanner.Text())
+			}
+		}
+		// Should a line be too long to scan, keep collecting the output
+		io.Copy(ioutil.Discard, tee)
+		err = cmd.Wait()
+		close(finished)
+		<-stopped
+		out.Cancelled = killed
+	}
+	// Stderr is kept whatever the outcome, since algorithms also write
+	// warnings and diagnostics there when they succeed
+	out.Stdout = stdout.Bytes()
+	out.Stderr = stderr.Bytes()
+	out.Error = err
 
 	if out.Error != nil {
 		if exitErr, ok := out.Error.(*exec.ExitError); ok {
 			workerlog.SimpleErr(cfg, "failed executing command; stderr below", exitErr)
-			workerlog.Alert(cfg, string(exitErr.Stderr))
-			out.Stderr = exitErr.Stderr
+			workerlog.Alert(cfg, stderr.String())
+			out.ExitCode = -1
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				out.ExitCode = status.ExitStatus()
+				if status.Signaled() {
+					out.Signal = status.Signal().String()
+				}
+			}
 		} else {
-			workerlog.SimpleErr(cfg, "failed executing command; stderr not available", err)
+			out.ExitCode = -1
+			workerlog.SimpleErr(cfg, "failed executing command; stderr below", out.Error)
+			workerlog.Alert(cfg, stderr.String())
 		}
 	} else {
 		workerlog.Info(cfg, "runCommandOutput success")
@@ -0,0 +1,76 @@
+// Copyright 2018, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package workerexec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/venicegeo/pzsvc-exec/pzsvc"
+	"github.com/venicegeo/pzsvc-exec/worker/config"
+)
+
+func TestRunCommandCancel(t *testing.T) {
+	cfg := config.WorkerConfig{Session: &pzsvc.Session{AppName: "test"}}
+
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(cancel)
+	}()
+	start := time.Now()
+	out := runCommand(cfg, "sleep 30", nil, cancel)
+	if !out.Cancelled || out.Signal == "" {
+		t.Errorf(`TestRunCommandCancel: cancelled run gave %+v.`, out)
+	}
+	if elapsed := time.Since(start); elapsed > killGracePeriod {
+		t.Errorf(`TestRunCommandCancel: cancel took %v.`, elapsed)
+	}
+
+	// A cancel that arrives after a clean exit does not mark the run cancelled
+	cancel = make(chan struct{})
+	lines := 0
+	out = runCommand(cfg, "echo done", func(line string) {
+		lines++
+	}, cancel)
+	close(cancel)
+	if out.Error != nil || out.Cancelled || lines != 1 {
+		t.Errorf(`TestRunCommandCancel: run cancelled after exit gave %+v.`, out)
+	}
+}
+
+func TestRunCommandStderr(t *testing.T) {
+	cfg := config.WorkerConfig{Session: &pzsvc.Session{AppName: "test"}}
+	testCases := []struct {
+		name    string
+		command string
+		failed  bool
+	}{
+		{"success", "echo warned >&2", false},
+		{"failure", "echo failed >&2; exit 3", true},
+	}
+	for _, tc := range testCases {
+		out := runCommand(cfg, tc.command, nil, nil)
+		if (out.Error != nil) != tc.failed {
+			t.Errorf(`TestRunCommandStderr: %s: unexpected error %v.`, tc.name, out.Error)
+		}
+		if len(out.Stderr) == 0 {
+			t.Errorf(`TestRunCommandStderr: %s: stderr not captured.`, tc.name)
+		}
+	}
+}